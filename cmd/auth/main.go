@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -20,8 +21,19 @@ const serviceName = "auth"
 type Config struct {
 	Port         string
 	JWTSecret    string
+	JWTKeysDir   string
+	JWTActiveKID string
 	PostgresDSN  string
 	MetricsToken string
+
+	SMTPAddr         string
+	SMTPFrom         string
+	SMTPUser         string
+	SMTPPassword     string
+	MailSinkFile     string
+	PasswordResetURL string
+	EmailVerifyURL   string
+	OIDCIssuer       string
 }
 
 func main() {
@@ -53,7 +65,12 @@ func run(log *zap.Logger) error {
 		return err
 	}
 
-	h := buildHTTPHandler(log, cfg, db)
+	jwt, err := buildTokenMaker(cfg)
+	if err != nil {
+		return err
+	}
+
+	h := buildHTTPHandler(log, cfg, db, jwt)
 
 	return kit.RunHTTPServer(":"+cfg.Port, h, log)
 }
@@ -62,16 +79,38 @@ func loadConfig() (Config, error) {
 	cfg := Config{
 		Port:         getenv("PORT", "8081"),
 		JWTSecret:    os.Getenv("JWT_SECRET"),
+		JWTKeysDir:   os.Getenv("JWT_KEYS_DIR"),
+		JWTActiveKID: os.Getenv("JWT_ACTIVE_KID"),
 		PostgresDSN:  os.Getenv("POSTGRES_DSN"),
 		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		SMTPAddr:         os.Getenv("SMTP_ADDR"),
+		SMTPFrom:         os.Getenv("SMTP_FROM"),
+		SMTPUser:         os.Getenv("SMTP_USER"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		MailSinkFile:     os.Getenv("MAIL_SINK_FILE"),
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+		EmailVerifyURL:   os.Getenv("EMAIL_VERIFY_URL"),
+		OIDCIssuer:       os.Getenv("OIDC_ISSUER"),
 	}
 
-	if len(cfg.JWTSecret) < 32 {
-		return Config{}, errors.New("JWT_SECRET is required and must be at least 32 chars")
+	if cfg.JWTKeysDir != "" {
+		if cfg.JWTActiveKID == "" {
+			return Config{}, errors.New("JWT_ACTIVE_KID is required with JWT_KEYS_DIR")
+		}
+	} else if len(cfg.JWTSecret) < 32 {
+		return Config{}, errors.New("JWT_KEYS_DIR or JWT_SECRET (at least 32 chars) is required")
 	}
 	if cfg.PostgresDSN == "" {
 		return Config{}, errors.New("POSTGRES_DSN is required")
 	}
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		return Config{}, errors.New("SMTP_FROM is required with SMTP_ADDR")
+	}
+	if u, err := url.Parse(cfg.OIDCIssuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") ||
+		u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return Config{}, errors.New("OIDC_ISSUER (absolute http(s) URL without query) is required")
+	}
 	return cfg, nil
 }
 
@@ -95,11 +134,27 @@ func pingDB(db *sql.DB, timeout time.Duration) error {
 	return db.PingContext(ctx)
 }
 
-func buildHTTPHandler(log *zap.Logger, cfg Config, db *sql.DB) http.Handler {
+func buildTokenMaker(cfg Config) (*auth.TokenMaker, error) {
+	if cfg.JWTKeysDir == "" {
+		return auth.NewTokenMaker(cfg.JWTSecret), nil
+	}
+
+	ks, err := auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKID)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewKeySetTokenMaker(ks), nil
+}
+
+func buildHTTPHandler(log *zap.Logger, cfg Config, db *sql.DB, jwt *auth.TokenMaker) http.Handler {
 	svc := &auth.Server{
-		Log:   log,
-		Store: auth.NewPostgresStore(db),
-		JWT:   auth.NewTokenMaker(cfg.JWTSecret),
+		Log:       log,
+		Store:     auth.NewPostgresStore(db),
+		JWT:       jwt,
+		Mailer:    buildMailer(log, cfg),
+		ResetURL:  cfg.PasswordResetURL,
+		VerifyURL: cfg.EmailVerifyURL,
+		Issuer:    cfg.OIDCIssuer,
 	}
 
 	reg := prometheus.NewRegistry()
@@ -113,6 +168,18 @@ func buildHTTPHandler(log *zap.Logger, cfg Config, db *sql.DB) http.Handler {
 	})
 }
 
+func buildMailer(log *zap.Logger, cfg Config) auth.Mailer {
+	switch {
+	case cfg.SMTPAddr != "":
+		return auth.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUser, cfg.SMTPPassword)
+	case cfg.MailSinkFile != "":
+		return auth.NewFileMailer(cfg.MailSinkFile)
+	default:
+		log.Warn("SMTP_ADDR not set, outgoing mail is only logged")
+		return auth.LogMailer{Log: log}
+	}
+}
+
 func getenv(key, def string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
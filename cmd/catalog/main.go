@@ -5,12 +5,14 @@ import (
 	"database/sql"
 	"errors"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
+	"MiniStore/internal/auth"
 	"MiniStore/internal/catalog"
 	"MiniStore/pkg/kit"
 )
@@ -19,9 +21,13 @@ const serviceName = "catalog"
 
 type Config struct {
 	Port          string
+	JWTSecret     string
+	JWKSURL       string
 	PostgresDSN   string
 	AllowMemStore bool
 
+	LowStockThreshold int
+
 	MetricsEnabled bool
 	MetricsToken   string
 }
@@ -50,8 +56,14 @@ func run(log *zap.Logger) error {
 	defer cleanup()
 
 	srv := &catalog.Server{
-		Store: store,
-		Log:   log,
+		Store:             store,
+		Log:               log,
+		LowStockThreshold: cfg.LowStockThreshold,
+	}
+	if cfg.JWKSURL != "" || cfg.JWTSecret != "" {
+		srv.JWT = auth.NewTokenVerifier(cfg.JWKSURL, cfg.JWTSecret)
+	} else {
+		log.Warn("JWKS_URL/JWT_SECRET are not set, product admin API is disabled")
 	}
 
 	reg := prometheus.NewRegistry()
@@ -69,6 +81,8 @@ func run(log *zap.Logger) error {
 func loadConfig() (Config, error) {
 	cfg := Config{
 		Port:          getenv("PORT", "8082"),
+		JWTSecret:     os.Getenv("JWT_SECRET"),
+		JWKSURL:       os.Getenv("JWKS_URL"),
 		PostgresDSN:   os.Getenv("POSTGRES_DSN"),
 		AllowMemStore: os.Getenv("ALLOW_MEMSTORE") == "1",
 
@@ -76,6 +90,16 @@ func loadConfig() (Config, error) {
 		MetricsToken:   os.Getenv("METRICS_TOKEN"),
 	}
 
+	threshold, err := strconv.Atoi(getenv("LOW_STOCK_THRESHOLD", strconv.Itoa(catalog.DefaultLowStockThreshold)))
+	if err != nil || threshold < 0 {
+		return Config{}, errors.New("LOW_STOCK_THRESHOLD must be a non-negative integer")
+	}
+	cfg.LowStockThreshold = threshold
+
+	if cfg.JWTSecret != "" && len(cfg.JWTSecret) < 32 {
+		return Config{}, errors.New("JWT_SECRET must be at least 32 chars")
+	}
+
 	if cfg.PostgresDSN == "" && !cfg.AllowMemStore {
 		return Config{}, errors.New("POSTGRES_DSN is required (set ALLOW_MEMSTORE=1 for dev)")
 	}
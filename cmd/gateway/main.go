@@ -16,6 +16,13 @@ const serviceName = "gateway"
 type Config struct {
 	Port      string
 	JWTSecret string
+	JWKSURL   string
+
+	IdentitySecret string
+	RevocationsURL string
+
+	ServiceClientID     string
+	ServiceClientSecret string
 
 	AuthURL    string
 	CatalogURL string
@@ -43,7 +50,14 @@ func run(log *zap.Logger) error {
 	reg := prometheus.NewRegistry()
 	h, err := gateway.NewHandler(
 		gateway.Deps{
-			JWTSecret:  cfg.JWTSecret,
+			JWTSecret:      cfg.JWTSecret,
+			JWKSURL:        cfg.JWKSURL,
+			IdentitySecret: cfg.IdentitySecret,
+			RevocationsURL: cfg.RevocationsURL,
+
+			ServiceClientID:     cfg.ServiceClientID,
+			ServiceClientSecret: cfg.ServiceClientSecret,
+
 			AuthURL:    cfg.AuthURL,
 			CatalogURL: cfg.CatalogURL,
 			OrderURL:   cfg.OrderURL,
@@ -67,6 +81,13 @@ func loadConfig() (Config, error) {
 	cfg := Config{
 		Port:      getenv("PORT", "8080"),
 		JWTSecret: os.Getenv("JWT_SECRET"),
+		JWKSURL:   os.Getenv("JWKS_URL"),
+
+		IdentitySecret: os.Getenv("IDENTITY_SECRET"),
+		RevocationsURL: os.Getenv("REVOCATIONS_URL"),
+
+		ServiceClientID:     os.Getenv("SERVICE_CLIENT_ID"),
+		ServiceClientSecret: os.Getenv("SERVICE_CLIENT_SECRET"),
 
 		AuthURL:    getenv("AUTH_URL", "http://auth:8081"),
 		CatalogURL: getenv("CATALOG_URL", "http://catalog:8082"),
@@ -76,8 +97,16 @@ func loadConfig() (Config, error) {
 		MetricsToken:   os.Getenv("METRICS_TOKEN"),
 	}
 
-	if len(cfg.JWTSecret) < 32 {
-		return Config{}, errors.New("JWT_SECRET is required and must be at least 32 chars")
+	if cfg.JWKSURL == "" && len(cfg.JWTSecret) < 32 {
+		return Config{}, errors.New("JWKS_URL or JWT_SECRET (at least 32 chars) is required")
+	}
+
+	if cfg.IdentitySecret != "" && len(cfg.IdentitySecret) < 32 {
+		return Config{}, errors.New("IDENTITY_SECRET must be at least 32 chars")
+	}
+
+	if cfg.RevocationsURL != "" && (cfg.ServiceClientID == "" || cfg.ServiceClientSecret == "") {
+		return Config{}, errors.New("REVOCATIONS_URL requires SERVICE_CLIENT_ID and SERVICE_CLIENT_SECRET")
 	}
 
 	return cfg, nil
@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
+	"MiniStore/internal/auth"
 	"MiniStore/internal/order"
 	"MiniStore/pkg/kit"
 )
@@ -21,15 +22,29 @@ const serviceName = "order"
 type Config struct {
 	Port       string
 	CatalogURL string
-	JWTSecret  string
+	AuthURL    string
+
+	ServiceClientID     string
+	ServiceClientSecret string
+
+	AuthMode       string
+	JWTSecret      string
+	JWKSURL        string
+	IdentitySecret string
+
+	RequireVerifiedEmail bool
 
 	PostgresDSN   string
 	AllowMemStore bool
 
+	IdempotencyTTL time.Duration
+
 	MetricsEnabled bool
 	MetricsToken   string
 }
 
+const idempotencyPurgeInterval = 10 * time.Minute
+
 func main() {
 	log := kit.NewLogger(serviceName)
 	defer func() { _ = log.Sync() }()
@@ -51,20 +66,36 @@ func run(log *zap.Logger) error {
 	}
 	defer cleanup()
 
+	var tokens auth.TokenSource
+	if cfg.ServiceClientID != "" {
+		tokens = auth.NewServiceTokenSource(cfg.AuthURL+"/auth/token", cfg.ServiceClientID, cfg.ServiceClientSecret)
+	} else {
+		log.Warn("SERVICE_CLIENT_ID/SERVICE_CLIENT_SECRET are not set, orders do not reserve catalog stock")
+	}
 	srv := &order.Server{
-		Store:   store,
-		Catalog: order.NewCatalogClient(cfg.CatalogURL),
-		Log:     log,
+		Store:          store,
+		Catalog:        order.NewCatalogClient(cfg.CatalogURL, tokens),
+		Log:            log,
+		IdempotencyTTL: cfg.IdempotencyTTL,
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.RunIdempotencyJanitor(ctx, idempotencyPurgeInterval)
+
 	reg := prometheus.NewRegistry()
 	h := order.NewHandler(srv, order.HTTPDeps{
 		Log:            log,
 		Service:        serviceName,
 		Registry:       reg,
+		AuthMode:       cfg.AuthMode,
 		JWTSecret:      cfg.JWTSecret,
+		JWKSURL:        cfg.JWKSURL,
+		IdentitySecret: cfg.IdentitySecret,
 		MetricsEnabled: cfg.MetricsEnabled,
 		MetricsToken:   cfg.MetricsToken,
+
+		RequireVerifiedEmail: cfg.RequireVerifiedEmail,
 	})
 
 	return kit.RunHTTPServer(":"+cfg.Port, h, log)
@@ -74,7 +105,17 @@ func loadConfig() (Config, error) {
 	cfg := Config{
 		Port:       getenv("PORT", "8083"),
 		CatalogURL: getenv("CATALOG_URL", "http://catalog:8082"),
-		JWTSecret:  os.Getenv("JWT_SECRET"),
+		AuthURL:    getenv("AUTH_URL", "http://auth:8081"),
+
+		ServiceClientID:     os.Getenv("SERVICE_CLIENT_ID"),
+		ServiceClientSecret: os.Getenv("SERVICE_CLIENT_SECRET"),
+
+		AuthMode:       getenv("AUTH_MODE", order.AuthModeJWT),
+		JWTSecret:      os.Getenv("JWT_SECRET"),
+		JWKSURL:        os.Getenv("JWKS_URL"),
+		IdentitySecret: os.Getenv("IDENTITY_SECRET"),
+
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "1",
 
 		PostgresDSN:   os.Getenv("POSTGRES_DSN"),
 		AllowMemStore: os.Getenv("ALLOW_MEMSTORE") == "1",
@@ -83,8 +124,23 @@ func loadConfig() (Config, error) {
 		MetricsToken:   os.Getenv("METRICS_TOKEN"),
 	}
 
-	if len(cfg.JWTSecret) < 32 {
-		return Config{}, errors.New("JWT_SECRET is required and must be at least 32 chars")
+	ttl, err := time.ParseDuration(getenv("IDEMPOTENCY_TTL", order.DefaultIdempotencyTTL.String()))
+	if err != nil || ttl <= 0 {
+		return Config{}, errors.New("IDEMPOTENCY_TTL is invalid")
+	}
+	cfg.IdempotencyTTL = ttl
+
+	switch cfg.AuthMode {
+	case order.AuthModeGateway:
+		if len(cfg.IdentitySecret) < 32 {
+			return Config{}, errors.New("IDENTITY_SECRET is required for AUTH_MODE=gateway and must be at least 32 chars")
+		}
+	case order.AuthModeJWT:
+		if cfg.JWKSURL == "" && len(cfg.JWTSecret) < 32 {
+			return Config{}, errors.New("JWKS_URL or JWT_SECRET (at least 32 chars) is required")
+		}
+	default:
+		return Config{}, errors.New("AUTH_MODE must be jwt or gateway")
 	}
 
 	if cfg.PostgresDSN == "" && !cfg.AllowMemStore {
@@ -94,6 +150,12 @@ func loadConfig() (Config, error) {
 	if _, err := http.NewRequest(http.MethodGet, cfg.CatalogURL, nil); err != nil {
 		return Config{}, errors.New("CATALOG_URL is invalid")
 	}
+	if _, err := http.NewRequest(http.MethodPost, cfg.AuthURL, nil); err != nil {
+		return Config{}, errors.New("AUTH_URL is invalid")
+	}
+	if (cfg.ServiceClientID == "") != (cfg.ServiceClientSecret == "") {
+		return Config{}, errors.New("SERVICE_CLIENT_ID and SERVICE_CLIENT_SECRET must be set together")
+	}
 
 	return cfg, nil
 }
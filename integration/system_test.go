@@ -146,9 +146,11 @@ func (e *e2eEnv) Login(email, password string) string {
 
 func (e *e2eEnv) Products(token string) []map[string]any {
 	e.t.Helper()
-	var out []map[string]any
+	var out struct {
+		Items []map[string]any `json:"items"`
+	}
 	e.mustJSON(http.MethodGet, "/products", token, nil, &out, http.StatusOK)
-	return out
+	return out.Items
 }
 
 type itemReq struct {
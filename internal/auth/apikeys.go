@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	apiKeyPrefix   = "msk_"
+	apiKeyIDBytes  = 6
+	apiKeyIDLen    = len(apiKeyPrefix) + 2*apiKeyIDBytes
+	maxServiceName = 100
+)
+
+var (
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrAPIKeyNotFound         = errors.New("api key not found")
+)
+
+type ServiceAccount struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Only a hash of the full key is kept; ID is its public part.
+type APIKey struct {
+	ID         string     `json:"id"`
+	AccountID  string     `json:"service_account_id"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type createServiceAccountReq struct {
+	Name string `json:"name"`
+}
+
+type createAPIKeyReq struct {
+	Scopes []string `json:"scopes"`
+}
+
+type createAPIKeyResp struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey returns msk_<id>_<secret>. The id is fixed length so it can be
+// split off even though the secret may contain '_'.
+func newAPIKey() (id, raw string, err error) {
+	idb := make([]byte, apiKeyIDBytes)
+	if _, err := rand.Read(idb); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	id = apiKeyPrefix + hex.EncodeToString(idb)
+	return id, id + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func apiKeyID(raw string) (string, bool) {
+	if len(raw) <= apiKeyIDLen+1 || !strings.HasPrefix(raw, apiKeyPrefix) || raw[apiKeyIDLen] != '_' {
+		return "", false
+	}
+	return raw[:apiKeyIDLen], true
+}
+
+func normalizeScopes(scopes []string) ([]string, bool) {
+	out := make([]string, 0, len(scopes))
+	for _, sc := range scopes {
+		sc = strings.TrimSpace(sc)
+		if !kit.IsKnownPermission(sc) {
+			return nil, false
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	slices.Sort(out)
+	return out, true
+}
+
+func (s *Server) handleCreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req createServiceAccountReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		badRequest(w, r, "name required", nil)
+		return
+	}
+	if len(name) > maxServiceName {
+		badRequest(w, r, "name too long", map[string]any{"max_len": maxServiceName})
+		return
+	}
+
+	sa := ServiceAccount{
+		ID:        "sa_" + uuid.NewString(),
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.Store.CreateServiceAccount(r.Context(), sa); err != nil {
+		s.err("create service account", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("service account created", zap.String("actor_id", p.UserID), zap.String("service_account_id", sa.ID))
+	}
+
+	kit.WriteJSON(w, http.StatusCreated, sa)
+}
+
+func (s *Server) handleListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Store.ListServiceAccounts(r.Context())
+	if err != nil {
+		s.err("list service accounts", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "id")
+
+	var req createAPIKeyReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	scopes, ok := normalizeScopes(req.Scopes)
+	if !ok {
+		badRequest(w, r, "unknown scope", map[string]any{"scopes": req.Scopes})
+		return
+	}
+
+	id, raw, err := newAPIKey()
+	if err != nil {
+		s.err("api key issue", err)
+		serverError(w, r)
+		return
+	}
+
+	key := APIKey{
+		ID:        id,
+		AccountID: accountID,
+		Hash:      hashToken(raw),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.Store.CreateAPIKey(r.Context(), key); err != nil {
+		if errors.Is(err, ErrServiceAccountNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "service account not found", map[string]any{"id": accountID})
+			return
+		}
+		s.err("create api key", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("api key created", zap.String("actor_id", p.UserID), zap.String("service_account_id", accountID),
+			zap.String("key_id", id), zap.Strings("scopes", scopes))
+	}
+
+	kit.WriteJSON(w, http.StatusCreated, createAPIKeyResp{APIKey: key, Key: raw})
+}
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "id")
+
+	items, err := s.Store.ListAPIKeys(r.Context(), accountID)
+	if err != nil {
+		if errors.Is(err, ErrServiceAccountNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "service account not found", map[string]any{"id": accountID})
+			return
+		}
+		s.err("list api keys", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "id")
+	keyID := chi.URLParam(r, "keyID")
+
+	if err := s.Store.RevokeAPIKey(r.Context(), accountID, keyID, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "api key not found", map[string]any{"id": keyID})
+			return
+		}
+		s.err("revoke api key", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("api key revoked", zap.String("actor_id", p.UserID), zap.String("key_id", keyID))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
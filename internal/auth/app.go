@@ -23,9 +23,19 @@ type HTTPDeps struct {
 }
 
 const (
-	loginLimitPerMin    = 5
-	registerLimitPerMin = 3
-	limitWindow         = 60 * time.Second
+	loginLimitPerMin     = 5
+	registerLimitPerMin  = 3
+	refreshLimitPerMin   = 30
+	forgotLimitPerMin    = 3
+	resetLimitPerMin     = 10
+	verifyLimitPerMin    = 10
+	resendLimitPerMin    = 3
+	mfaLimitPerMin       = 10
+	passwordLimitPerMin  = 5
+	profileLimitPerMin   = 10
+	tokenLimitPerMin     = 30
+	authorizeLimitPerMin = 5
+	limitWindow          = 60 * time.Second
 )
 
 func NewHandler(s *Server, deps HTTPDeps) http.Handler {
@@ -36,6 +46,10 @@ func NewHandler(s *Server, deps HTTPDeps) http.Handler {
 		deps.Log.Warn("metrics enabled but Registry is nil")
 	}
 
+	if deps.Registry != nil && s.Metrics == nil {
+		s.Metrics = NewLoginMetrics(deps.Registry)
+	}
+
 	setupMiddleware(r, deps, metricsOn)
 	setupRoutes(r, s, deps, metricsOn)
 
@@ -56,13 +70,99 @@ func setupMiddleware(r *chi.Mux, deps HTTPDeps, metricsOn bool) {
 func setupRoutes(r *chi.Mux, s *Server, deps HTTPDeps, metricsOn bool) {
 	loginLimiter := kit.NewIPRateLimiter(loginLimitPerMin, int(limitWindow.Seconds()))
 	registerLimiter := kit.NewIPRateLimiter(registerLimitPerMin, int(limitWindow.Seconds()))
+	refreshLimiter := kit.NewIPRateLimiter(refreshLimitPerMin, int(limitWindow.Seconds()))
+	forgotLimiter := kit.NewIPRateLimiter(forgotLimitPerMin, int(limitWindow.Seconds()))
+	resetLimiter := kit.NewIPRateLimiter(resetLimitPerMin, int(limitWindow.Seconds()))
+	verifyLimiter := kit.NewIPRateLimiter(verifyLimitPerMin, int(limitWindow.Seconds()))
+	mfaLimiter := kit.NewIPRateLimiter(mfaLimitPerMin, int(limitWindow.Seconds()))
+	resendLimiter := kit.NewIPRateLimiter(resendLimitPerMin, int(limitWindow.Seconds()))
+	passwordLimiter := kit.NewIPRateLimiter(passwordLimitPerMin, int(limitWindow.Seconds()))
+	profileLimiter := kit.NewIPRateLimiter(profileLimitPerMin, int(limitWindow.Seconds()))
+	tokenLimiter := kit.NewIPRateLimiter(tokenLimitPerMin, int(limitWindow.Seconds()))
+	authorizeLimiter := kit.NewIPRateLimiter(authorizeLimitPerMin, int(limitWindow.Seconds()))
 
 	r.Route("/auth", func(rr chi.Router) {
 		rr.With(loginLimiter.Middleware).Post("/login", s.handleLogin)
+		rr.With(mfaLimiter.Middleware).Post("/login/mfa", s.handleLoginMFA)
 		rr.With(registerLimiter.Middleware).Post("/register", s.handleRegister)
-		rr.Get("/whoami", s.handleWhoAmI)
+		rr.With(refreshLimiter.Middleware).Post("/refresh", s.handleRefresh)
+		rr.With(tokenLimiter.Middleware).Post("/token", s.handleToken)
+		rr.Post("/logout", s.handleLogout)
+		rr.With(forgotLimiter.Middleware).Post("/password/forgot", s.handleForgotPassword)
+		rr.With(resetLimiter.Middleware).Post("/password/reset", s.handleResetPassword)
+		rr.With(verifyLimiter.Middleware).Post("/verify-email", s.handleVerifyEmail)
+		rr.With(resendLimiter.Middleware).Post("/verify-email/resend", s.handleResendVerification)
+		if s.Issuer != "" {
+			rr.Get("/authorize", s.handleAuthorize)
+			rr.With(authorizeLimiter.Middleware).Post("/authorize", s.handleAuthorizeLogin)
+		}
+		rr.With(s.authenticate).Get("/whoami", s.handleUserInfo)
+		rr.With(s.authenticate).Get("/userinfo", s.handleUserInfo)
+		rr.With(s.authenticate).Post("/userinfo", s.handleUserInfo)
+		rr.With(s.authenticate, kit.RequirePermission(kit.PermTokensIntrospect)).Post("/introspect", s.handleIntrospect)
+		rr.With(passwordLimiter.Middleware, s.authenticate).Post("/password", s.handleChangePassword)
+
+		rr.Route("/sessions", func(sr chi.Router) {
+			sr.Use(s.authenticate)
+			sr.Get("/", s.handleListSessions)
+			sr.Delete("/{id}", s.handleRevokeSession)
+		})
+
+		rr.Route("/me", func(mr chi.Router) {
+			mr.Use(s.authenticate)
+			mr.Get("/", s.handleGetProfile)
+			mr.With(profileLimiter.Middleware).Patch("/", s.handleUpdateProfile)
+			mr.With(profileLimiter.Middleware).Delete("/", s.handleDeleteAccount)
+		})
+
+		rr.Route("/mfa/totp", func(mr chi.Router) {
+			mr.Use(s.authenticate)
+			mr.Post("/enroll", s.handleEnrollTOTP)
+			mr.With(mfaLimiter.Middleware).Post("/confirm", s.handleConfirmTOTP)
+			mr.With(mfaLimiter.Middleware).Post("/disable", s.handleDisableTOTP)
+		})
+
+		rr.Route("/admin/users", func(ar chi.Router) {
+			ar.Use(s.authenticate)
+			ar.With(kit.RequirePermission(kit.PermUsersRead)).Get("/", s.handleListUsers)
+			ar.With(kit.RequirePermission(kit.PermUsersRead)).Get("/{id}", s.handleGetUser)
+			ar.With(kit.RequirePermission(kit.PermUsersRolesWrite)).Put("/{id}/role", s.handleGrantRole)
+			ar.With(kit.RequirePermission(kit.PermUsersRolesWrite)).Delete("/{id}/role", s.handleRevokeRole)
+			ar.With(kit.RequirePermission(kit.PermUsersWrite)).Post("/{id}/unlock", s.handleUnlockUser)
+			ar.With(kit.RequirePermission(kit.PermUsersWrite)).Post("/{id}/disable", s.handleDisableUser)
+			ar.With(kit.RequirePermission(kit.PermUsersWrite)).Post("/{id}/enable", s.handleEnableUser)
+			ar.With(kit.RequirePermission(kit.PermUsersWrite)).Post("/{id}/password-reset", s.handleForcePasswordReset)
+		})
+
+		rr.With(s.authenticate, kit.RequirePermission(kit.PermUsersWrite)).Post("/admin/revocations", s.handleRevoke)
+		rr.With(s.authenticate, kit.RequirePermission(kit.PermAuditRead)).Get("/audit", s.handleListAudit)
+
+		rr.Route("/admin/service-accounts", func(ar chi.Router) {
+			ar.Use(s.authenticate, kit.RequirePermission(kit.PermServiceAccounts))
+			ar.Post("/", s.handleCreateServiceAccount)
+			ar.Get("/", s.handleListServiceAccounts)
+			ar.Post("/{id}/keys", s.handleCreateAPIKey)
+			ar.Get("/{id}/keys", s.handleListAPIKeys)
+			ar.Delete("/{id}/keys/{keyID}", s.handleRevokeAPIKey)
+		})
+
+		rr.Route("/admin/clients", func(ar chi.Router) {
+			ar.Use(s.authenticate, kit.RequirePermission(kit.PermOAuthClients))
+			ar.Post("/", s.handleCreateClient)
+			ar.Get("/", s.handleListClients)
+		})
 	})
 
+	r.Get("/.well-known/jwks.json", s.handleJWKS)
+	if s.Issuer != "" {
+		r.Get("/.well-known/openid-configuration", s.handleDiscovery)
+	}
+	r.With(s.authenticate, kit.RequirePermission(kit.PermRevocationsRead)).Get("/internal/revocations", s.handleListRevocations)
+	r.Route("/internal/users/{id}", func(ir chi.Router) {
+		ir.Use(s.authenticate, kit.RequirePermission(kit.PermPersonalData))
+		ir.Get("/export", s.handleExportUser)
+		ir.Post("/erase", s.handleEraseUser)
+	})
 	r.Get("/healthz", healthz)
 	r.Get("/readyz", s.handleReady)
 
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	AuditRegistered          = "user.registered"
+	AuditLoginSucceeded      = "login.succeeded"
+	AuditLoginFailed         = "login.failed"
+	AuditTokenIssued         = "token.issued"
+	AuditRoleChanged         = "role.changed"
+	AuditPasswordChanged     = "password.changed"
+	AuditPasswordReset       = "password.reset"
+	AuditAccountDeleted      = "account.deleted"
+	AuditUserUnlocked        = "user.unlocked"
+	AuditSessionRevoked      = "session.revoked"
+	AuditUserDisabled        = "user.disabled"
+	AuditUserEnabled         = "user.enabled"
+	AuditPasswordResetForced = "password.reset_forced"
+	AuditUserExported        = "user.exported"
+	AuditUserErased          = "user.erased"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+var errBadAuditCursor = errors.New("bad cursor")
+
+// ActorID is set when someone else, such as an admin, acted on UserID.
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	UserID    string            `json:"user_id,omitempty"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type AuditQuery struct {
+	UserID   string
+	Type     string
+	Since    *time.Time
+	Until    *time.Time
+	BeforeID int64
+	Limit    int
+}
+
+func (q AuditQuery) matches(e AuditEvent) bool {
+	switch {
+	case q.UserID != "" && e.UserID != q.UserID:
+		return false
+	case q.Type != "" && e.Type != q.Type:
+		return false
+	case q.Since != nil && e.CreatedAt.Before(*q.Since):
+		return false
+	case q.Until != nil && !e.CreatedAt.Before(*q.Until):
+		return false
+	case q.BeforeID > 0 && e.ID >= q.BeforeID:
+		return false
+	}
+	return true
+}
+
+// A failed audit write is logged but never fails the request.
+func (s *Server) audit(r *http.Request, typ, userID string, details map[string]string) {
+	e := AuditEvent{
+		Type:      typ,
+		UserID:    userID,
+		IP:        kit.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: chimw.GetReqID(r.Context()),
+		Details:   details,
+		CreatedAt: time.Now().UTC(),
+	}
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && p.UserID != userID {
+		e.ActorID = p.UserID
+	}
+
+	if err := s.Store.AppendAudit(r.Context(), e); err != nil {
+		s.warn("audit append", err)
+	}
+}
+
+type auditResp struct {
+	Items      []AuditEvent `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+func parseAuditQuery(v url.Values) (AuditQuery, error) {
+	q := AuditQuery{
+		UserID: v.Get("user_id"),
+		Type:   v.Get("type"),
+		Limit:  defaultAuditLimit,
+	}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			return AuditQuery{}, errors.New("bad limit")
+		}
+		q.Limit = n
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		s := v.Get(f.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return AuditQuery{}, errors.New("bad " + f.name)
+		}
+		*f.dst = &t
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return AuditQuery{}, errBadAuditCursor
+		}
+		id, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil || id <= 0 {
+			return AuditQuery{}, errBadAuditCursor
+		}
+		q.BeforeID = id
+	}
+
+	return q, nil
+}
+
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		badRequest(w, r, err.Error(), nil)
+		return
+	}
+
+	want := q.Limit
+	q.Limit++
+	items, err := s.Store.ListAudit(r.Context(), q)
+	if err != nil {
+		s.err("list audit", err)
+		serverError(w, r)
+		return
+	}
+
+	resp := auditResp{Items: items}
+	if len(items) > want {
+		resp.Items = items[:want]
+		last := resp.Items[want-1].ID
+		resp.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(last, 10)))
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	authCodeTTL    = 1 * time.Minute
+	maxOAuthParam  = 512
+	authorizeTitle = "Sign in to MiniStore"
+
+	csrfCookie = "ministore_authorize_csrf"
+	csrfField  = "csrf_token"
+	csrfTTL    = 15 * time.Minute
+)
+
+var ErrAuthCodeInvalid = errors.New("invalid authorization code")
+
+// AuthCode keeps the browser's details for the session opened at the token
+// exchange.
+type AuthCode struct {
+	Hash          string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	UserAgent     string
+	IP            string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+func checkRedeemable(ac AuthCode, now time.Time) error {
+	if ac.UsedAt != nil || !now.Before(ac.ExpiresAt) {
+		return ErrAuthCodeInvalid
+	}
+	return nil
+}
+
+type authorizeReq struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeReq(v url.Values) authorizeReq {
+	return authorizeReq{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		Scope:               strings.Join(strings.Fields(v.Get("scope")), " "),
+		State:               v.Get("state"),
+		Nonce:               v.Get("nonce"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+type authorizePage struct {
+	Title      string
+	ClientName string
+	Error      string
+	Email      string
+	AskCode    bool
+	CSRF       string
+	Req        authorizeReq
+}
+
+var authorizeTmpl = template.Must(template.New("authorize").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 1rem; padding: .5rem; }
+button { padding: .6rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .ClientName}}<p><strong>{{.ClientName}}</strong> wants to sign you in.</p>{{end}}
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+{{if .Req.ClientID}}
+<form method="post" action="/auth/authorize">
+<input type="hidden" name="response_type" value="{{.Req.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Req.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Req.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Req.Scope}}">
+<input type="hidden" name="state" value="{{.Req.State}}">
+<input type="hidden" name="nonce" value="{{.Req.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Req.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Req.CodeChallengeMethod}}">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<label for="email">Email</label>
+<input id="email" name="email" type="email" value="{{.Email}}" autocomplete="username" required>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+{{if .AskCode}}<label for="code">Authentication code</label>
+<input id="code" name="code" inputmode="numeric" autocomplete="one-time-code" required>{{end}}
+<button type="submit">Sign in</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func (s *Server) renderAuthorize(w http.ResponseWriter, status int, page authorizePage) {
+	page.Title = authorizeTitle
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := authorizeTmpl.Execute(w, page); err != nil {
+		s.warn("authorize render", err)
+	}
+}
+
+// Double-submit CSRF: the same token goes into a cookie and a hidden field.
+func (s *Server) setCSRF(w http.ResponseWriter) (string, error) {
+	raw, _, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    raw,
+		Path:     "/auth/authorize",
+		MaxAge:   int(csrfTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.issuer(), "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return raw, nil
+}
+
+func checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostForm.Get(csrfField))) == 1
+}
+
+// Only used once the redirect URI is known to be registered.
+func redirectError(w http.ResponseWriter, r *http.Request, req authorizeReq, code, desc string) {
+	redirectTo(w, r, req.RedirectURI, url.Values{"error": {code}, "error_description": {desc}}, req.State)
+}
+
+func redirectTo(w http.ResponseWriter, r *http.Request, redirectURI string, q url.Values, state string) {
+	u, _ := url.Parse(redirectURI)
+	v := u.Query()
+	for k, vals := range q {
+		v[k] = vals
+	}
+	if state != "" {
+		v.Set("state", state)
+	}
+	u.RawQuery = v.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// Problems with the client or redirect URI are shown to the user; anything
+// else goes back to the client.
+func (s *Server) checkAuthorize(w http.ResponseWriter, r *http.Request, req authorizeReq) (OAuthClient, bool) {
+	if req.ClientID == "" || req.RedirectURI == "" {
+		s.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "client_id and redirect_uri are required."})
+		return OAuthClient{}, false
+	}
+
+	c, err := s.Store.GetOAuthClient(r.Context(), req.ClientID)
+	if err != nil {
+		if !errors.Is(err, ErrClientNotFound) {
+			s.err("authorize client lookup", err)
+			s.renderAuthorize(w, http.StatusInternalServerError, authorizePage{Error: "Something went wrong, please try again."})
+			return OAuthClient{}, false
+		}
+		s.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "Unknown application."})
+		return OAuthClient{}, false
+	}
+	if !c.allowsRedirect(req.RedirectURI) {
+		s.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "This redirect URI is not registered for the application."})
+		return OAuthClient{}, false
+	}
+
+	for _, p := range []string{req.Scope, req.State, req.Nonce, req.CodeChallenge} {
+		if len(p) > maxOAuthParam {
+			redirectError(w, r, req, "invalid_request", "parameter too long")
+			return OAuthClient{}, false
+		}
+	}
+	if req.ResponseType != "code" {
+		redirectError(w, r, req, "unsupported_response_type", "only response_type=code is supported")
+		return OAuthClient{}, false
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != pkceMethodS256 {
+		redirectError(w, r, req, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return OAuthClient{}, false
+	}
+	scopes := strings.Fields(req.Scope)
+	if !slices.Contains(scopes, scopeOpenID) {
+		redirectError(w, r, req, "invalid_scope", "openid scope is required")
+		return OAuthClient{}, false
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(supportedScopes, sc) {
+			redirectError(w, r, req, "invalid_scope", "unsupported scope "+sc)
+			return OAuthClient{}, false
+		}
+	}
+
+	return c, true
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeReq(r.URL.Query())
+	c, ok := s.checkAuthorize(w, r, req)
+	if !ok {
+		return
+	}
+
+	csrf, err := s.setCSRF(w)
+	if err != nil {
+		s.err("authorize csrf", err)
+		s.renderAuthorize(w, http.StatusInternalServerError, authorizePage{Error: "Something went wrong, please try again."})
+		return
+	}
+
+	s.renderAuthorize(w, http.StatusOK, authorizePage{ClientName: c.Name, CSRF: csrf, Req: req})
+}
+
+func (s *Server) handleAuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		s.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "Bad request."})
+		return
+	}
+
+	req := parseAuthorizeReq(r.PostForm)
+	c, ok := s.checkAuthorize(w, r, req)
+	if !ok {
+		return
+	}
+
+	email := normalizeEmail(r.PostForm.Get("email"))
+	password := normalizePassword(r.PostForm.Get("password"))
+	code := strings.TrimSpace(r.PostForm.Get("code"))
+	page := authorizePage{ClientName: c.Name, Email: email, Req: req, AskCode: code != ""}
+
+	fail := func(status int, msg string) {
+		page.Error = msg
+		s.renderAuthorize(w, status, page)
+	}
+
+	// A post without the matching cookie did not come from our own form;
+	// show a fresh form instead of checking the password.
+	if !checkCSRF(r) {
+		csrf, err := s.setCSRF(w)
+		if err != nil {
+			s.err("authorize csrf", err)
+			page.Req = authorizeReq{}
+			fail(http.StatusInternalServerError, "Something went wrong, please try again.")
+			return
+		}
+		page.CSRF = csrf
+		fail(http.StatusForbidden, "Your sign-in form expired, please try again.")
+		return
+	}
+	page.CSRF = r.PostForm.Get(csrfField)
+
+	if email == "" || password == "" {
+		fail(http.StatusBadRequest, "Email and password are required.")
+		return
+	}
+
+	now := time.Now().UTC()
+	if s.loginLocked(r, email, password, now) {
+		fail(http.StatusUnauthorized, "Invalid email or password.")
+		return
+	}
+
+	u, err := s.Store.Verify(r.Context(), email, password)
+	if err != nil {
+		if errors.Is(err, ErrUserDisabled) {
+			s.auditDisabledLogin(r, email)
+			fail(http.StatusForbidden, "This account has been disabled.")
+			return
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			s.warn("authorize verify failed", err)
+			fail(http.StatusUnauthorized, "Invalid email or password.")
+			return
+		}
+		s.recordLoginFailure(r, email, "invalid_credentials", now)
+		fail(http.StatusUnauthorized, "Invalid email or password.")
+		return
+	}
+
+	enabled, err := s.mfaEnabled(r.Context(), u.ID)
+	if err != nil {
+		s.err("authorize mfa lookup", err)
+		fail(http.StatusInternalServerError, "Something went wrong, please try again.")
+		return
+	}
+	method := "password"
+	if enabled {
+		page.AskCode = true
+		if code == "" {
+			fail(http.StatusUnauthorized, "Enter the code from your authenticator app.")
+			return
+		}
+		if err := s.checkMFACode(r.Context(), u.ID, code, now); err != nil {
+			if !errors.Is(err, ErrMFACodeInvalid) && !errors.Is(err, ErrMFACodeReused) {
+				s.err("authorize mfa check", err)
+				fail(http.StatusInternalServerError, "Something went wrong, please try again.")
+				return
+			}
+			s.recordLoginFailure(r, u.Email, "invalid_mfa_code", now)
+			fail(http.StatusUnauthorized, "Invalid authentication code.")
+			return
+		}
+		method = "mfa"
+	}
+
+	if u.FailedLogins > 0 {
+		if err := s.Store.ClearLoginFailures(r.Context(), u.ID); err != nil {
+			s.warn("authorize clear failures", err)
+		}
+	}
+
+	raw, hash, err := newOpaqueToken()
+	if err != nil {
+		s.err("auth code issue", err)
+		fail(http.StatusInternalServerError, "Something went wrong, please try again.")
+		return
+	}
+	if err := s.Store.CreateAuthCode(r.Context(), AuthCode{
+		Hash:          hash,
+		ClientID:      c.ID,
+		UserID:        u.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		UserAgent:     clientUserAgent(r),
+		IP:            kit.ClientIP(r),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(authCodeTTL),
+	}); err != nil {
+		s.err("auth code store", err)
+		fail(http.StatusInternalServerError, "Something went wrong, please try again.")
+		return
+	}
+
+	s.audit(r, AuditLoginSucceeded, u.ID, map[string]string{"method": method, "client_id": c.ID})
+
+	redirectTo(w, r, req.RedirectURI, url.Values{"code": {raw}}, req.State)
+}
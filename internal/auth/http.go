@@ -15,12 +15,24 @@ import (
 	"MiniStore/pkg/kit"
 )
 
-const maxBodyBytes = 1 << 20
+const (
+	maxBodyBytes   = 1 << 20
+	minPasswordLen = 8
+)
 
 type Server struct {
 	Log   *zap.Logger
 	Store UserStore
 	JWT   *TokenMaker
+
+	Mailer    Mailer
+	ResetURL  string
+	VerifyURL string
+
+	// Without Issuer the OIDC endpoints are not served.
+	Issuer string
+
+	Metrics *LoginMetrics
 }
 
 func (s *Server) warn(msg string, err error) {
@@ -83,6 +95,44 @@ func bearerToken(r *http.Request) (string, bool) {
 	return strings.TrimSpace(strings.TrimPrefix(authz, "Bearer ")), true
 }
 
+type claimsKey struct{}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, ok := bearerToken(r)
+		if !ok || tok == "" {
+			unauthorized(w, r, "missing token")
+			return
+		}
+
+		claims, err := s.JWT.Parse(tok)
+		if err != nil || claims.UserID == "" {
+			unauthorized(w, r, "invalid token")
+			return
+		}
+
+		revoked, err := s.accessRevoked(r.Context(), claims)
+		if err != nil {
+			s.err("revocation check", err)
+			serverError(w, r)
+			return
+		}
+		if revoked {
+			unauthorized(w, r, "invalid token")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+		ctx = kit.WithPrincipal(ctx, claims.Principal())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func claimsFromContext(ctx context.Context) Claims {
+	c, _ := ctx.Value(claimsKey{}).(Claims)
+	return c
+}
+
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
@@ -114,8 +164,8 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, r, "email/password required", nil)
 		return
 	}
-	if len(req.Password) < 8 {
-		badRequest(w, r, "password too short", map[string]any{"min_len": 8})
+	if len(req.Password) < minPasswordLen {
+		badRequest(w, r, "password too short", map[string]any{"min_len": minPasswordLen})
 		return
 	}
 
@@ -130,6 +180,9 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, AuditRegistered, id, nil)
+	s.sendVerification(r.Context(), User{ID: id, Email: req.Email})
+
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -138,8 +191,17 @@ type loginReq struct {
 	Password string `json:"password"`
 }
 
-type loginResp struct {
-	AccessToken string `json:"access_token"`
+type tokenResp struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -157,41 +219,263 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A blocked account gets the same answer as a wrong password, whatever the
+	// password, so the lockout neither confirms a guess nor reveals the email.
+	now := time.Now().UTC()
+	if s.loginLocked(r, req.Email, req.Password, now) {
+		unauthorized(w, r, "invalid credentials")
+		return
+	}
+
 	u, err := s.Store.Verify(r.Context(), req.Email, req.Password)
 	if err != nil {
-		unauthorized(w, r, "invalid credentials")
+		if errors.Is(err, ErrUserDisabled) {
+			s.auditDisabledLogin(r, req.Email)
+			accountDisabled(w, r)
+			return
+		}
 		if !errors.Is(err, ErrInvalidCredentials) {
+			unauthorized(w, r, "invalid credentials")
 			s.warn("login verify failed", err)
+			return
 		}
+		s.recordLoginFailure(r, req.Email, "invalid_credentials", now)
+		unauthorized(w, r, "invalid credentials")
 		return
 	}
 
-	tok, err := s.JWT.New(u.ID, u.Email, u.Role, 15*time.Minute)
+	enabled, err := s.mfaEnabled(r.Context(), u.ID)
 	if err != nil {
-		s.err("token issue", err)
+		s.err("login mfa lookup", err)
 		serverError(w, r)
 		return
 	}
+	if enabled {
+		s.writeMFAChallenge(w, r, u)
+		return
+	}
 
-	kit.WriteJSON(w, http.StatusOK, loginResp{AccessToken: tok})
+	s.audit(r, AuditLoginSucceeded, u.ID, map[string]string{"method": "password"})
+	s.completeLogin(w, r, u, "password")
 }
 
-func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
-	tok, ok := bearerToken(r)
-	if !ok || tok == "" {
-		unauthorized(w, r, "missing token")
+// completeLogin runs once every factor has been checked.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, u User, grant string) {
+	if u.FailedLogins > 0 {
+		if err := s.Store.ClearLoginFailures(r.Context(), u.ID); err != nil {
+			s.warn("login clear failures", err)
+		}
+	}
+
+	sess := newSession(r, u.ID, time.Now().UTC())
+	rawRefresh, err := s.startSession(r.Context(), sess, "")
+	if err != nil {
+		s.err("session start", err)
+		serverError(w, r)
 		return
 	}
 
-	claims, err := s.JWT.Parse(tok)
+	s.writeTokens(w, r, u, rawRefresh, sess.ID, grant)
+}
+
+func (s *Server) startSession(ctx context.Context, sess Session, clientID string) (string, error) {
+	raw, hash, err := newOpaqueToken()
 	if err != nil {
-		unauthorized(w, r, "invalid token")
+		return "", err
+	}
+	if err := s.Store.CreateSession(ctx, sess); err != nil {
+		return "", err
+	}
+	if err := s.Store.CreateRefreshToken(ctx, RefreshToken{
+		Hash:      hash,
+		FamilyID:  sess.ID,
+		UserID:    sess.UserID,
+		ClientID:  clientID,
+		CreatedAt: sess.CreatedAt,
+		ExpiresAt: sess.CreatedAt.Add(refreshTokenTTL),
+	}); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := decodeJSON(w, r, &req); err != nil || req.RefreshToken == "" {
+		badRequest(w, r, "refresh_token required", nil)
 		return
 	}
 
-	kit.WriteJSON(w, http.StatusOK, map[string]any{
-		"user_id": claims.UserID,
-		"email":   claims.Email,
-		"role":    claims.Role,
+	u, next, rawNext, ok := s.rotateRefresh(w, r, req.RefreshToken, "")
+	if !ok {
+		return
+	}
+
+	s.writeTokens(w, r, u, rawNext, next.FamilyID, grantRefreshToken)
+}
+
+// clientID is empty for tokens from POST /auth/login. On failure the response
+// has been written.
+func (s *Server) rotateRefresh(w http.ResponseWriter, r *http.Request, raw, clientID string) (User, RefreshToken, string, bool) {
+	rawNext, nextHash, err := newOpaqueToken()
+	if err != nil {
+		s.err("refresh token issue", err)
+		serverError(w, r)
+		return User{}, RefreshToken{}, "", false
+	}
+
+	now := time.Now().UTC()
+	next, err := s.Store.RotateRefreshToken(r.Context(), hashToken(raw), nextHash, clientID, now, now.Add(refreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenClient) && clientID != "":
+			badRequest(w, r, "invalid grant", nil)
+		case errors.Is(err, ErrRefreshTokenReused):
+			s.warn("refresh token reuse detected, family revoked", err)
+			unauthorized(w, r, "invalid refresh token")
+		case errors.Is(err, ErrRefreshTokenInvalid), errors.Is(err, ErrRefreshTokenClient):
+			unauthorized(w, r, "invalid refresh token")
+		default:
+			s.err("refresh token rotate", err)
+			serverError(w, r)
+		}
+		return User{}, RefreshToken{}, "", false
+	}
+
+	u, err := s.Store.GetByID(r.Context(), next.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid refresh token")
+			return User{}, RefreshToken{}, "", false
+		}
+		s.err("refresh user lookup", err)
+		serverError(w, r)
+		return User{}, RefreshToken{}, "", false
+	}
+	if u.Disabled() {
+		unauthorized(w, r, "invalid refresh token")
+		return User{}, RefreshToken{}, "", false
+	}
+
+	if err := s.Store.TouchSession(r.Context(), next.FamilyID, kit.ClientIP(r), clientUserAgent(r), now); err != nil {
+		s.warn("session touch", err)
+	}
+	return u, next, rawNext, true
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := decodeJSON(w, r, &req); err != nil || req.RefreshToken == "" {
+		badRequest(w, r, "refresh_token required", nil)
+		return
+	}
+
+	if err := s.Store.RevokeRefreshFamily(r.Context(), hashToken(req.RefreshToken), time.Now().UTC()); err != nil {
+		s.err("logout revoke", err)
+		serverError(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		badRequest(w, r, "email required", nil)
+		return
+	}
+
+	s.startPasswordReset(r.Context(), email)
+
+	kit.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"status": "if the email is registered, a reset link has been sent",
 	})
 }
+
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	req.Password = normalizePassword(req.Password)
+	if req.Token == "" || req.Password == "" {
+		badRequest(w, r, "token/password required", nil)
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		badRequest(w, r, "password too short", map[string]any{"min_len": minPasswordLen})
+		return
+	}
+
+	userID, err := s.Store.ResetPassword(r.Context(), hashToken(req.Token), req.Password, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, ErrResetTokenInvalid) {
+			badRequest(w, r, "invalid or expired token", nil)
+			return
+		}
+		s.err("password reset", err)
+		serverError(w, r)
+		return
+	}
+
+	s.audit(r, AuditPasswordReset, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) accessToken(u User, sessionID string) (string, error) {
+	return s.JWT.Issue(Claims{
+		UserID:        u.ID,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		SessionID:     sessionID,
+	}, accessTokenTTL)
+}
+
+func (s *Server) writeTokens(w http.ResponseWriter, r *http.Request, u User, refreshToken, sessionID, grant string) {
+	tok, err := s.accessToken(u, sessionID)
+	if err != nil {
+		s.err("token issue", err)
+		serverError(w, r)
+		return
+	}
+
+	s.audit(r, AuditTokenIssued, u.ID, map[string]string{"grant": grant})
+
+	kit.WriteJSON(w, http.StatusOK, tokenResp{
+		AccessToken:  tok,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	ks := s.JWT.KeySet()
+	if ks == nil {
+		kit.WriteJSON(w, http.StatusOK, JWKS{Keys: []JWK{}})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	kit.WriteJSON(w, http.StatusOK, ks.JWKS())
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	jwksFetchTimeout      = 3 * time.Second
+	jwksDefaultTTL        = 5 * time.Minute
+	jwksMinRefreshBackoff = 10 * time.Second
+)
+
+var ErrJWKSBadStatus = errors.New("jwks bad status")
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (ks *KeySet) JWKS() JWKS {
+	pub := ks.PublicKeys()
+	out := JWKS{Keys: make([]JWK, 0, len(pub))}
+	for _, pk := range pub {
+		if jwk, err := toJWK(pk); err == nil {
+			out.Keys = append(out.Keys, jwk)
+		}
+	}
+	return out
+}
+
+func toJWK(pk PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding
+
+	switch key := pk.Key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA", Kid: pk.ID, Alg: pk.Alg, Use: "sig",
+			N: b64.EncodeToString(key.N.Bytes()),
+			E: b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP", Kid: pk.ID, Alg: pk.Alg, Use: "sig",
+			Crv: "Ed25519",
+			X:   b64.EncodeToString(key),
+		}, nil
+	default:
+		return JWK{}, ErrUnsupportedKey
+	}
+}
+
+func fromJWK(k JWK) (PublicKey, error) {
+	b64 := base64.RawURLEncoding
+
+	switch {
+	case k.Kty == "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		return PublicKey{ID: k.Kid, Alg: AlgRS256, Key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, ErrUnsupportedKey
+		}
+		return PublicKey{ID: k.Kid, Alg: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	default:
+		return PublicKey{}, fmt.Errorf("%w: kty=%s crv=%s", ErrUnsupportedKey, k.Kty, k.Crv)
+	}
+}
+
+type JWKSClient struct {
+	URL    string
+	Client *http.Client
+	TTL    time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+func NewJWKSClient(url string) *JWKSClient {
+	return &JWKSClient{
+		URL:    url,
+		Client: &http.Client{Timeout: jwksFetchTimeout},
+		TTL:    jwksDefaultTTL,
+	}
+}
+
+func (c *JWKSClient) Lookup(kid string) (PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	pk, known := c.keys[kid]
+	stale := now.Sub(c.fetchedAt) > c.TTL
+
+	if (stale || !known) && now.Sub(c.lastAttempt) > jwksMinRefreshBackoff {
+		c.lastAttempt = now
+		if keys, err := c.fetch(); err == nil {
+			c.keys = keys
+			c.fetchedAt = now
+			pk, known = c.keys[kid]
+		} else if c.keys == nil {
+			return PublicKey{}, err
+		}
+	}
+
+	if !known {
+		return PublicKey{}, ErrUnknownKey
+	}
+	return pk, nil
+}
+
+func (c *JWKSClient) fetch() (map[string]PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status=%d", ErrJWKSBadStatus, resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		pk, err := fromJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[pk.ID] = pk
+	}
+	return keys, nil
+}
@@ -2,9 +2,13 @@ package auth
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"MiniStore/pkg/kit"
 )
 
 const (
@@ -20,11 +24,17 @@ var (
 	ErrTokenExpired   = errors.New("token expired")
 	ErrMissingUserID  = errors.New("missing user_id")
 	ErrInvalidSubject = errors.New("invalid subject")
+	ErrWrongPurpose   = errors.New("wrong token purpose")
 )
 
 type TokenMaker struct {
 	secret []byte
 	issuer string
+
+	keySet *KeySet
+	keys   KeyResolver
+
+	revocations RevocationChecker
 }
 
 func NewTokenMaker(secret string) *TokenMaker {
@@ -34,33 +44,110 @@ func NewTokenMaker(secret string) *TokenMaker {
 	}
 }
 
+func NewKeySetTokenMaker(ks *KeySet) *TokenMaker {
+	return &TokenMaker{
+		issuer: defaultIssuer,
+		keySet: ks,
+		keys:   ks,
+	}
+}
+
+func NewVerifier(keys KeyResolver) *TokenMaker {
+	return &TokenMaker{
+		issuer: defaultIssuer,
+		keys:   keys,
+	}
+}
+
+func NewTokenVerifier(jwksURL, secret string) *TokenMaker {
+	if jwksURL != "" {
+		return NewVerifier(NewJWKSClient(jwksURL))
+	}
+	return NewTokenMaker(secret)
+}
+
+func (t *TokenMaker) KeySet() *KeySet {
+	return t.keySet
+}
+
+func (t *TokenMaker) Alg() string {
+	if t.keySet == nil {
+		return jwt.SigningMethodHS256.Alg()
+	}
+	_, _, method, err := t.keySet.signer()
+	if err != nil {
+		return ""
+	}
+	return method.Alg()
+}
+
+func (t *TokenMaker) WithRevocations(rc RevocationChecker) *TokenMaker {
+	t.revocations = rc
+	return t
+}
+
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	Purpose       string `json:"purpose,omitempty"`
+	Scope         string `json:"scope,omitempty"`
+	SessionID     string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c Claims) Principal() kit.Principal {
+	return kit.Principal{UserID: c.UserID, Role: c.Role, Scopes: c.Scopes()}
+}
+
 func (t *TokenMaker) New(userID, email, role string, ttl time.Duration) (string, error) {
+	return t.Issue(Claims{UserID: userID, Email: email, Role: role}, ttl)
+}
+
+func (t *TokenMaker) Issue(claims Claims, ttl time.Duration) (string, error) {
 	now := time.Now()
 
-	claims := Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   userID,
-			Issuer:    t.issuer,
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   claims.UserID,
+		Issuer:    t.issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	return t.sign(claims)
+}
+
+func (t *TokenMaker) sign(claims jwt.Claims) (string, error) {
+	if t.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(t.secret)
+	}
+
+	if t.keySet == nil {
+		return "", ErrNoSigningKey
+	}
+
+	kid, key, method, err := t.keySet.signer()
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(t.secret)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
 }
 
+// Parse accepts access tokens only; see ParsePurpose.
 func (t *TokenMaker) Parse(tokenString string) (Claims, error) {
+	return t.ParsePurpose(tokenString, "")
+}
+
+func (t *TokenMaker) ParsePurpose(tokenString, purpose string) (Claims, error) {
 	var c Claims
 
 	token, err := jwt.ParseWithClaims(tokenString, &c, t.keyFunc())
@@ -88,14 +175,38 @@ func (t *TokenMaker) Parse(tokenString string) (Claims, error) {
 		return Claims{}, ErrInvalidSubject
 	}
 
+	if c.Purpose != purpose {
+		return Claims{}, ErrWrongPurpose
+	}
+
+	if purpose == "" && t.revocations != nil && t.revocations.Revoked(c) {
+		return Claims{}, ErrTokenRevoked
+	}
+
 	return c, nil
 }
 
 func (t *TokenMaker) keyFunc() jwt.Keyfunc {
 	return func(token *jwt.Token) (any, error) {
-		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+		if t.keys == nil {
+			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+				return nil, ErrUnexpectedAlg
+			}
+			return t.secret, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, ErrUnknownKey
+		}
+
+		pk, err := t.keys.Lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != pk.Alg {
 			return nil, ErrUnexpectedAlg
 		}
-		return t.secret, nil
+		return pk.Key, nil
 	}
 }
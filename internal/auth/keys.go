@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown key id")
+	ErrNoSigningKey   = errors.New("no signing key")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+type PublicKey struct {
+	ID  string
+	Alg string
+	Key crypto.PublicKey
+}
+
+type KeyResolver interface {
+	Lookup(kid string) (PublicKey, error)
+}
+
+type KeySet struct {
+	active  string
+	signers map[string]crypto.Signer
+	public  map[string]PublicKey
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		signers: make(map[string]crypto.Signer),
+		public:  make(map[string]PublicKey),
+	}
+}
+
+func (ks *KeySet) AddSigner(kid string, key crypto.Signer) error {
+	alg, err := algForKey(key.Public())
+	if err != nil {
+		return err
+	}
+
+	ks.signers[kid] = key
+	ks.public[kid] = PublicKey{ID: kid, Alg: alg, Key: key.Public()}
+	return nil
+}
+
+func (ks *KeySet) AddPublic(kid string, key crypto.PublicKey) error {
+	alg, err := algForKey(key)
+	if err != nil {
+		return err
+	}
+
+	ks.public[kid] = PublicKey{ID: kid, Alg: alg, Key: key}
+	return nil
+}
+
+func (ks *KeySet) SetActive(kid string) error {
+	if _, ok := ks.signers[kid]; !ok {
+		return fmt.Errorf("%w: %q", ErrNoSigningKey, kid)
+	}
+	ks.active = kid
+	return nil
+}
+
+func (ks *KeySet) Lookup(kid string) (PublicKey, error) {
+	pk, ok := ks.public[kid]
+	if !ok {
+		return PublicKey{}, ErrUnknownKey
+	}
+	return pk, nil
+}
+
+func (ks *KeySet) PublicKeys() []PublicKey {
+	out := make([]PublicKey, 0, len(ks.public))
+	for _, pk := range ks.public {
+		out = append(out, pk)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (ks *KeySet) signer() (string, crypto.Signer, jwt.SigningMethod, error) {
+	key, ok := ks.signers[ks.active]
+	if !ok {
+		return "", nil, nil, ErrNoSigningKey
+	}
+	return ks.active, key, signingMethod(ks.public[ks.active].Alg), nil
+}
+
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := NewKeySet()
+	for _, f := range files {
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		if err := ks.loadPEM(kid, f); err != nil {
+			return nil, fmt.Errorf("load key %s: %w", f, err)
+		}
+	}
+
+	if err := ks.SetActive(activeKID); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) loadPEM(kid, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return errors.New("no PEM block")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return ErrUnsupportedKey
+		}
+		return ks.AddSigner(kid, signer)
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		return ks.AddSigner(kid, key)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		return ks.AddPublic(kid, key)
+	default:
+		return fmt.Errorf("%w: PEM type %q", ErrUnsupportedKey, block.Type)
+	}
+}
+
+func algForKey(key crypto.PublicKey) (string, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return AlgRS256, nil
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	default:
+		return "", ErrUnsupportedKey
+	}
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLockFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{loginDelayAfter - 1, 0},
+		{loginDelayAfter, 1 * time.Second},
+		{loginDelayAfter + 1, 2 * time.Second},
+		{loginDelayAfter + 2, 4 * time.Second},
+		{loginDelayAfter + 5, 32 * time.Second},
+		{loginDelayAfter + 6, maxLoginDelay},
+		{maxFailedLogins - 1, maxLoginDelay},
+		{maxFailedLogins, lockoutDuration},
+		{maxFailedLogins + 50, lockoutDuration},
+	}
+
+	for _, tt := range tests {
+		if got := loginLockFor(tt.failures); got != tt.want {
+			t.Errorf("loginLockFor(%d)=%v want=%v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockRemaining(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name  string
+		until *time.Time
+		want  time.Duration
+	}{
+		{"never locked", nil, 0},
+		{"expired", at(-time.Second), 0},
+		{"ends now", at(0), 0},
+		{"locked", at(90 * time.Second), 90 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockRemaining(User{LockedUntil: tt.until}, now); got != tt.want {
+				t.Fatalf("got=%v want=%v", got, tt.want)
+			}
+		})
+	}
+}
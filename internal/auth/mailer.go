@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const mailSendTimeout = 10 * time.Second
+
+var errInvalidMailHeader = errors.New("mail: invalid header value")
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if hasNewline(mail.To) || hasNewline(mail.Subject) {
+		return errInvalidMailHeader
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{mail.To}, formatMail(m.From, mail))
+}
+
+type LogMailer struct {
+	Log *zap.Logger
+}
+
+func (m LogMailer) Send(_ context.Context, mail Mail) error {
+	if m.Log != nil {
+		m.Log.Info("mail sent",
+			zap.String("to", mail.To),
+			zap.String("subject", mail.Subject),
+			zap.String("body", mail.Body),
+		)
+	}
+	return nil
+}
+
+type FileMailer struct {
+	mu   sync.Mutex
+	Path string
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{Path: path}
+}
+
+func (m *FileMailer) Send(_ context.Context, mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(formatMail("", mail), "\r\n"...)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Server) mailer() Mailer {
+	if s.Mailer != nil {
+		return s.Mailer
+	}
+	return LogMailer{Log: s.Log}
+}
+
+// sendMailAsync keeps delivery out of the response time, so endpoints that
+// must not reveal whether an account exists answer uniformly.
+func (s *Server) sendMailAsync(ctx context.Context, userID string, mail Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+		defer cancel()
+
+		if err := s.mailer().Send(ctx, mail); err != nil && s.Log != nil {
+			s.Log.Error("mail send failed", zap.Error(err), zap.String("user_id", userID), zap.String("subject", mail.Subject))
+		}
+	}()
+}
+
+func formatMail(from string, mail Mail) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + from + "\r\n")
+	}
+	b.WriteString("To: " + mail.To + "\r\n")
+	b.WriteString("Subject: " + mail.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(mail.Body)
+	return []byte(b.String())
+}
+
+func hasNewline(s string) bool {
+	return strings.ContainsAny(s, "\r\n")
+}
@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	purposeMFA        = "mfa"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFANotEnrolled    = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrMFACodeInvalid    = errors.New("invalid mfa code")
+	ErrMFACodeReused     = errors.New("mfa code already used")
+)
+
+type TOTP struct {
+	UserID      string
+	Secret      string
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+type enrollResp struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type mfaCodeReq struct {
+	Code string `json:"code"`
+}
+
+type disableTOTPReq struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+type recoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type mfaChallengeResp struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type loginMFAReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+func (s *Server) mfaEnabled(ctx context.Context, userID string) (bool, error) {
+	t, err := s.Store.GetTOTP(ctx, userID)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.ConfirmedAt != nil, nil
+}
+
+func (s *Server) writeMFAChallenge(w http.ResponseWriter, r *http.Request, u User) {
+	tok, err := s.JWT.Issue(Claims{UserID: u.ID, Email: u.Email, Purpose: purposeMFA}, mfaChallengeTTL)
+	if err != nil {
+		s.err("mfa challenge issue", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, mfaChallengeResp{
+		MFARequired: true,
+		MFAToken:    tok,
+		ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+func (s *Server) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req loginMFAReq
+	if err := decodeJSON(w, r, &req); err != nil || req.MFAToken == "" || req.Code == "" {
+		badRequest(w, r, "mfa_token/code required", nil)
+		return
+	}
+
+	claims, err := s.JWT.ParsePurpose(req.MFAToken, purposeMFA)
+	if err != nil {
+		unauthorized(w, r, "invalid mfa token")
+		return
+	}
+
+	u, err := s.Store.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid mfa token")
+			return
+		}
+		s.err("login mfa user lookup", err)
+		serverError(w, r)
+		return
+	}
+	if u.Disabled() {
+		s.audit(r, AuditLoginFailed, u.ID, map[string]string{"reason": "disabled"})
+		accountDisabled(w, r)
+		return
+	}
+
+	now := time.Now().UTC()
+	if retry := lockRemaining(u, now); retry > 0 {
+		s.Metrics.lockedAttempt()
+		s.audit(r, AuditLoginFailed, u.ID, map[string]string{"reason": "locked"})
+		tooManyAttempts(w, r, retry)
+		return
+	}
+
+	if err := s.checkMFACode(r.Context(), u.ID, req.Code, now); err != nil {
+		if !errors.Is(err, ErrMFACodeInvalid) && !errors.Is(err, ErrMFACodeReused) {
+			s.err("login mfa check", err)
+			serverError(w, r)
+			return
+		}
+		s.recordLoginFailure(r, u.Email, "invalid_mfa_code", now)
+		unauthorized(w, r, "invalid mfa code")
+		return
+	}
+
+	s.audit(r, AuditLoginSucceeded, u.ID, map[string]string{"method": "mfa"})
+	s.completeLogin(w, r, u, "mfa")
+}
+
+// checkMFACode accepts either a current TOTP code or an unused recovery code.
+func (s *Server) checkMFACode(ctx context.Context, userID, code string, now time.Time) error {
+	t, err := s.Store.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrMFANotEnrolled) {
+			return ErrMFACodeInvalid
+		}
+		return err
+	}
+	if t.ConfirmedAt == nil {
+		return ErrMFACodeInvalid
+	}
+
+	if step, ok := matchTOTP(t.Secret, code, now); ok {
+		return s.Store.UseTOTPStep(ctx, userID, step)
+	}
+
+	return s.Store.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)), now)
+}
+
+func (s *Server) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := kit.PrincipalFromContext(r.Context())
+
+	u, err := s.Store.GetByID(r.Context(), p.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid token")
+			return
+		}
+		s.err("mfa enroll user lookup", err)
+		serverError(w, r)
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		s.err("mfa secret issue", err)
+		serverError(w, r)
+		return
+	}
+
+	if err := s.Store.SetPendingTOTP(r.Context(), u.ID, secret); err != nil {
+		if errors.Is(err, ErrMFAAlreadyEnabled) {
+			conflict(w, r, "mfa already enabled")
+			return
+		}
+		s.err("mfa enroll", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, enrollResp{
+		Secret:     secret,
+		OTPAuthURI: totpURI(secret, u.Email),
+	})
+}
+
+func (s *Server) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	p, _ := kit.PrincipalFromContext(r.Context())
+
+	var req mfaCodeReq
+	if err := decodeJSON(w, r, &req); err != nil || req.Code == "" {
+		badRequest(w, r, "code required", nil)
+		return
+	}
+
+	t, err := s.Store.GetTOTP(r.Context(), p.UserID)
+	if err != nil {
+		if errors.Is(err, ErrMFANotEnrolled) {
+			conflict(w, r, "mfa enrollment not started")
+			return
+		}
+		s.err("mfa confirm lookup", err)
+		serverError(w, r)
+		return
+	}
+	if t.ConfirmedAt != nil {
+		conflict(w, r, "mfa already enabled")
+		return
+	}
+
+	now := time.Now().UTC()
+	step, ok := matchTOTP(t.Secret, req.Code, now)
+	if !ok {
+		badRequest(w, r, "invalid code", nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		s.err("recovery codes issue", err)
+		serverError(w, r)
+		return
+	}
+
+	if err := s.Store.EnableTOTP(r.Context(), p.UserID, step, hashes, now); err != nil {
+		if errors.Is(err, ErrMFAAlreadyEnabled) {
+			conflict(w, r, "mfa already enabled")
+			return
+		}
+		s.err("mfa confirm", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, recoveryCodesResp{RecoveryCodes: codes})
+}
+
+// A stolen access token alone cannot remove the second factor.
+func (s *Server) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req disableTOTPReq
+	if err := decodeJSON(w, r, &req); err != nil || req.Code == "" || req.Password == "" {
+		badRequest(w, r, "code and password required", nil)
+		return
+	}
+
+	u, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !s.confirmPassword(w, r, u, req.Password) {
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.checkMFACode(r.Context(), u.ID, req.Code, now); err != nil {
+		if !errors.Is(err, ErrMFACodeInvalid) && !errors.Is(err, ErrMFACodeReused) {
+			s.err("mfa disable check", err)
+			serverError(w, r)
+			return
+		}
+		s.recordLoginFailure(r, u.Email, "invalid_mfa_code", now)
+		badRequest(w, r, "invalid code", nil)
+		return
+	}
+
+	if err := s.Store.DeleteTOTP(r.Context(), u.ID); err != nil {
+		s.err("mfa disable", err)
+		serverError(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, recoveryCodeCount)
+	hashes = make([]string, recoveryCodeCount)
+
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for j, c := range buf {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryAlphabet[c&31])
+		}
+		codes[i] = b.String()
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
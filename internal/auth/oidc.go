@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	scopeOpenID  = "openid"
+	scopeEmail   = "email"
+	scopeProfile = "profile"
+
+	pkceMethodS256 = "S256"
+	minVerifierLen = 43
+	maxVerifierLen = 128
+	idTokenTTL     = accessTokenTTL
+)
+
+var supportedScopes = []string{scopeOpenID, scopeEmail, scopeProfile}
+
+// IDClaims are never accepted by Parse.
+type IDClaims struct {
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	Name          string           `json:"name,omitempty"`
+	Nonce         string           `json:"nonce,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	SessionID     string           `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (t *TokenMaker) IssueIDToken(c IDClaims, issuer, subject, audience string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	c.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   subject,
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	return t.sign(c)
+}
+
+// issuer is never taken from the request's Host.
+func (s *Server) issuer() string {
+	return strings.TrimSuffix(s.Issuer, "/")
+}
+
+type discoveryResp struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	iss := s.issuer()
+
+	kit.WriteJSON(w, http.StatusOK, discoveryResp{
+		Issuer:                            iss,
+		AuthorizationEndpoint:             iss + "/auth/authorize",
+		TokenEndpoint:                     iss + "/auth/token",
+		UserInfoEndpoint:                  iss + "/auth/userinfo",
+		JWKSURI:                           iss + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{grantAuthorizationCode, grantRefreshToken, grantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.JWT.Alg()},
+		ScopesSupported:                   supportedScopes,
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid", "email", "email_verified", "name"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+	})
+}
+
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < minVerifierLen || len(verifier) > maxVerifierLen {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(challenge)) == 1
+}
+
+type userInfoResp struct {
+	Sub           string `json:"sub"`
+	UserID        string `json:"user_id"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+	Role          string `json:"role"`
+}
+
+// Service tokens have no account behind them and are answered from claims.
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	resp := userInfoResp{
+		Sub:           claims.UserID,
+		UserID:        claims.UserID,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Role:          claims.Role,
+	}
+	if claims.Role != kit.RoleService {
+		u, ok := s.currentUser(w, r)
+		if !ok {
+			return
+		}
+		resp.Email = u.Email
+		resp.EmailVerified = u.EmailVerified
+		resp.Name = u.DisplayName
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) idToken(u User, ac AuthCode, sessionID string) (string, error) {
+	scopes := strings.Fields(ac.Scope)
+	c := IDClaims{
+		Nonce:     ac.Nonce,
+		AuthTime:  jwt.NewNumericDate(ac.CreatedAt),
+		SessionID: sessionID,
+	}
+	if slices.Contains(scopes, scopeEmail) {
+		verified := u.EmailVerified
+		c.Email, c.EmailVerified = u.Email, &verified
+	}
+	if slices.Contains(scopes, scopeProfile) {
+		c.Name = u.DisplayName
+	}
+	return s.JWT.IssueIDToken(c, s.issuer(), u.ID, ac.ClientID, idTokenTTL)
+}
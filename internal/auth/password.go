@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errBadPasswordHash = errors.New("malformed password hash")
+
+// PasswordHasher only makes new hashes; stored hashes describe their own
+// algorithm, so checkPassword accepts any supported format.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	NeedsRehash(hash []byte) bool
+}
+
+// Argon2idHasher encodes hashes in PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// NewArgon2idHasher uses the OWASP baseline of 19 MiB, 2 passes, 1 thread.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:  19 * 1024,
+		Time:    2,
+		Threads: 1,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (h *Argon2idHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Appendf(nil, "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash []byte) bool {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory || p.time != h.Time || p.threads != h.Threads ||
+		len(p.salt) != int(h.SaltLen) || len(p.key) != int(h.KeyLen)
+}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// minArgon2idSaltLen is the shortest salt the Argon2 spec allows.
+const minArgon2idSaltLen = 8
+
+func parseArgon2id(hash []byte) (argon2idHash, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return argon2idHash{}, errBadPasswordHash
+	}
+
+	// Sscanf stops at the last verb, so the fields are re-encoded and
+	// compared to reject trailing input.
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version ||
+		parts[2] != fmt.Sprintf("v=%d", version) {
+		return argon2idHash{}, errBadPasswordHash
+	}
+
+	var p argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil ||
+		parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", p.memory, p.time, p.threads) {
+		return argon2idHash{}, errBadPasswordHash
+	}
+	if p.memory == 0 || p.time == 0 || p.threads == 0 {
+		return argon2idHash{}, errBadPasswordHash
+	}
+
+	var err error
+	if p.salt, err = phcEncoding.DecodeString(parts[4]); err != nil || len(p.salt) < minArgon2idSaltLen {
+		return argon2idHash{}, errBadPasswordHash
+	}
+	if p.key, err = phcEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return argon2idHash{}, errBadPasswordHash
+	}
+	return p, nil
+}
+
+// BcryptHasher only looks at the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+func isBcryptHash(hash []byte) bool {
+	for _, p := range []string{"$2a$", "$2b$", "$2y$"} {
+		if bytes.HasPrefix(hash, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashPassword(h PasswordHasher, password string) ([]byte, error) {
+	return h.Hash(normalizePassword(password))
+}
+
+func checkPassword(hash []byte, password string) bool {
+	password = normalizePassword(password)
+
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		p, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	case isBcryptHash(hash):
+		return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	default:
+		return false
+	}
+}
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	h, _ := NewArgon2idHasher().Hash("dummy password for unknown users")
+	return h
+})
+
+// compareDummyPassword spends the same hashing time as a real check, so
+// unknown emails cannot be told apart by response latency.
+func compareDummyPassword(password string) {
+	_ = checkPassword(dummyPasswordHash(), password)
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const maxDisplayNameLen = 100
+
+type profileResp struct {
+	UserID        string    `json:"user_id"`
+	Email         string    `json:"email"`
+	DisplayName   string    `json:"display_name"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type updateProfileReq struct {
+	DisplayName     *string `json:"display_name"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+type changePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type deleteAccountReq struct {
+	Password string `json:"password"`
+}
+
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	claims := claimsFromContext(r.Context())
+
+	u, err := s.Store.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid token")
+			return User{}, false
+		}
+		s.err("current user lookup", err)
+		serverError(w, r)
+		return User{}, false
+	}
+	return u, true
+}
+
+// Wrong guesses count towards the login lockout.
+func (s *Server) confirmPassword(w http.ResponseWriter, r *http.Request, u User, password string) bool {
+	now := time.Now().UTC()
+	if retry := lockRemaining(u, now); retry > 0 {
+		s.Metrics.lockedAttempt()
+		tooManyAttempts(w, r, retry)
+		return false
+	}
+
+	if checkPassword(u.Hash, normalizePassword(password)) {
+		return true
+	}
+
+	s.recordLoginFailure(r, u.Email, "invalid_password", now)
+	kit.WriteError(w, r, http.StatusForbidden, "invalid password", nil)
+	return false
+}
+
+func (s *Server) writeProfile(w http.ResponseWriter, r *http.Request, u User) {
+	mfa, err := s.mfaEnabled(r.Context(), u.ID)
+	if err != nil {
+		s.err("profile mfa lookup", err)
+		serverError(w, r)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, profileResp{
+		UserID:        u.ID,
+		Email:         u.Email,
+		DisplayName:   u.DisplayName,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		MFAEnabled:    mfa,
+		CreatedAt:     u.CreatedAt,
+	})
+}
+
+func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	s.writeProfile(w, r, u)
+}
+
+func (s *Server) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var req updateProfileReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+	if req.DisplayName == nil && req.Email == nil {
+		badRequest(w, r, "display_name/email required", nil)
+		return
+	}
+
+	var upd ProfileUpdate
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLen {
+			badRequest(w, r, "display_name too long", map[string]any{"max_len": maxDisplayNameLen})
+			return
+		}
+		upd.DisplayName = &name
+	}
+
+	u, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Email != nil {
+		email := normalizeEmail(*req.Email)
+		if email == "" {
+			badRequest(w, r, "email required", nil)
+			return
+		}
+		if email != u.Email {
+			if req.CurrentPassword == "" {
+				badRequest(w, r, "current_password required", nil)
+				return
+			}
+			if !s.confirmPassword(w, r, u, req.CurrentPassword) {
+				return
+			}
+			upd.Email = &email
+		}
+	}
+
+	updated, err := s.Store.UpdateProfile(r.Context(), u.ID, upd)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEmailExists):
+			conflict(w, r, "email already exists")
+		case errors.Is(err, ErrUserNotFound):
+			unauthorized(w, r, "invalid token")
+		default:
+			s.err("update profile", err)
+			serverError(w, r)
+		}
+		return
+	}
+
+	if upd.Email != nil {
+		s.sendVerification(r.Context(), updated)
+		if s.Log != nil {
+			s.Log.Info("email changed", zap.String("user_id", u.ID))
+		}
+	}
+
+	s.writeProfile(w, r, updated)
+}
+
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	req.NewPassword = normalizePassword(req.NewPassword)
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		badRequest(w, r, "current_password/new_password required", nil)
+		return
+	}
+	if len(req.NewPassword) < minPasswordLen {
+		badRequest(w, r, "password too short", map[string]any{"min_len": minPasswordLen})
+		return
+	}
+
+	u, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !s.confirmPassword(w, r, u, req.CurrentPassword) {
+		return
+	}
+
+	if err := s.Store.ChangePassword(r.Context(), u.ID, req.NewPassword, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid token")
+			return
+		}
+		s.err("change password", err)
+		serverError(w, r)
+		return
+	}
+
+	if s.Log != nil {
+		s.Log.Info("password changed", zap.String("user_id", u.ID))
+	}
+	s.audit(r, AuditPasswordChanged, u.ID, nil)
+
+	// Every other session was revoked; hand the caller a fresh pair.
+	u.FailedLogins = 0
+	s.completeLogin(w, r, u, "password_change")
+}
+
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req deleteAccountReq
+	if err := decodeJSON(w, r, &req); err != nil || req.Password == "" {
+		badRequest(w, r, "password required", nil)
+		return
+	}
+
+	u, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !s.confirmPassword(w, r, u, req.Password) {
+		return
+	}
+
+	if err := s.Store.DeleteUser(r.Context(), u.ID, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			unauthorized(w, r, "invalid token")
+			return
+		}
+		s.err("delete account", err)
+		serverError(w, r)
+		return
+	}
+
+	if s.Log != nil {
+		s.Log.Info("account deleted", zap.String("user_id", u.ID))
+	}
+	s.audit(r, AuditAccountDeleted, u.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
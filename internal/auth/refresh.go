@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	accessTokenTTL   = 15 * time.Minute
+	refreshTokenTTL  = 30 * 24 * time.Hour
+	opaqueTokenBytes = 32
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrRefreshTokenClient  = errors.New("refresh token issued to another client")
+)
+
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    string
+	ClientID  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func newOpaqueToken() (raw, hash string, err error) {
+	b := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw = base64.RawURLEncoding.EncodeToString(b)
+	return raw, hashToken(raw), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func checkRotatable(t RefreshToken, clientID string, now time.Time) error {
+	if t.ClientID != clientID {
+		return ErrRefreshTokenClient
+	}
+	if t.RevokedAt != nil {
+		return ErrRefreshTokenInvalid
+	}
+	if t.UsedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	if !now.Before(t.ExpiresAt) {
+		return ErrRefreshTokenInvalid
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const passwordResetTTL = 1 * time.Hour
+
+var ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+
+type PasswordReset struct {
+	Hash      string
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func checkResettable(pr PasswordReset, now time.Time) error {
+	if pr.UsedAt != nil || !now.Before(pr.ExpiresAt) {
+		return ErrResetTokenInvalid
+	}
+	return nil
+}
+
+func (s *Server) startPasswordReset(ctx context.Context, email string) {
+	u, err := s.Store.GetByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			s.err("password reset lookup", err)
+		}
+		return
+	}
+
+	raw, hash, err := newOpaqueToken()
+	if err != nil {
+		s.err("password reset token issue", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.Store.CreatePasswordReset(ctx, PasswordReset{
+		Hash:      hash,
+		UserID:    u.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}); err != nil {
+		s.err("password reset store", err)
+		return
+	}
+
+	s.sendMailAsync(ctx, u.ID, Mail{
+		To:      u.Email,
+		Subject: "Reset your MiniStore password",
+		Body:    s.resetMailBody(raw),
+	})
+}
+
+func (s *Server) resetMailBody(token string) string {
+	link := "reset token: " + token
+	if s.ResetURL != "" {
+		link = s.ResetURL + "?token=" + token
+	}
+
+	return fmt.Sprintf(
+		"Someone requested a password reset for your account.\n\n%s\n\n"+
+			"The token expires in %d minutes and can be used once. "+
+			"If you did not request a reset, you can ignore this email.\n",
+		link, int(passwordResetTTL.Minutes()),
+	)
+}
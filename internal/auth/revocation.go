@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	revocationFetchTimeout = 3 * time.Second
+	revocationDefaultPoll  = 5 * time.Second
+)
+
+var (
+	ErrTokenRevoked         = errors.New("token revoked")
+	ErrRevocationsBadStatus = errors.New("revocations bad status")
+)
+
+// Revocations drop out once the tokens they cover have expired anyway.
+type Revocations struct {
+	Tokens   []RevokedToken   `json:"tokens"`
+	Users    []RevokedUser    `json:"users"`
+	Sessions []RevokedSession `json:"sessions"`
+}
+
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RevokedUser struct {
+	UserID       string    `json:"user_id"`
+	IssuedBefore time.Time `json:"issued_before"`
+}
+
+type RevokedSession struct {
+	SessionID string    `json:"session_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+type RevocationChecker interface {
+	Revoked(c Claims) bool
+}
+
+// iat has one-second precision, so the cutoff is truncated: a token issued
+// later in the same second (the pair handed out after a password change)
+// stays valid.
+func userCutoff(now time.Time) time.Time {
+	return now.Truncate(time.Second)
+}
+
+func issuedBefore(c Claims, cutoff time.Time) bool {
+	return c.IssuedAt == nil || c.IssuedAt.Time.Before(cutoff)
+}
+
+// After revocationTTL every token issued before a cutoff has expired.
+const revocationTTL = accessTokenTTL + expLeeway
+
+type RevocationClient struct {
+	URL    string
+	Client *http.Client
+	Poll   time.Duration
+	Tokens TokenSource
+
+	list atomic.Pointer[revocationList]
+
+	mu          sync.Mutex
+	fetching    bool
+	lastAttempt time.Time
+}
+
+type revocationList struct {
+	tokens   map[string]struct{}
+	users    map[string]time.Time
+	sessions map[string]struct{}
+}
+
+func newRevocationList(rv Revocations) *revocationList {
+	l := &revocationList{
+		tokens:   make(map[string]struct{}, len(rv.Tokens)),
+		users:    make(map[string]time.Time, len(rv.Users)),
+		sessions: make(map[string]struct{}, len(rv.Sessions)),
+	}
+	for _, t := range rv.Tokens {
+		l.tokens[t.JTI] = struct{}{}
+	}
+	for _, u := range rv.Users {
+		l.users[u.UserID] = u.IssuedBefore
+	}
+	for _, sess := range rv.Sessions {
+		l.sessions[sess.SessionID] = struct{}{}
+	}
+	return l
+}
+
+func NewRevocationClient(url string, tokens TokenSource) *RevocationClient {
+	return &RevocationClient{
+		URL:    url,
+		Client: &http.Client{Timeout: revocationFetchTimeout},
+		Poll:   revocationDefaultPoll,
+		Tokens: tokens,
+	}
+}
+
+// Revoked never waits for auth: the list is refreshed in the background at
+// most once per Poll, and the last known list is used meanwhile.
+func (c *RevocationClient) Revoked(claims Claims) bool {
+	c.refreshIfDue()
+
+	l := c.list.Load()
+	if l == nil {
+		return false
+	}
+	if _, ok := l.tokens[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+	if _, ok := l.sessions[claims.SessionID]; ok && claims.SessionID != "" {
+		return true
+	}
+	cutoff, ok := l.users[claims.UserID]
+	return ok && issuedBefore(claims, cutoff)
+}
+
+func (c *RevocationClient) refreshIfDue() {
+	c.mu.Lock()
+	now := time.Now()
+	due := !c.fetching && now.Sub(c.lastAttempt) > c.Poll
+	if due {
+		c.fetching, c.lastAttempt = true, now
+	}
+	c.mu.Unlock()
+
+	if due {
+		go c.refresh()
+	}
+}
+
+func (c *RevocationClient) refresh() {
+	if rv, err := c.fetch(); err == nil {
+		c.list.Store(newRevocationList(rv))
+	}
+
+	c.mu.Lock()
+	c.fetching = false
+	c.mu.Unlock()
+}
+
+func (c *RevocationClient) fetch() (Revocations, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), revocationFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return Revocations{}, err
+	}
+	if c.Tokens != nil {
+		tok, err := c.Tokens.Token(ctx)
+		if err != nil {
+			return Revocations{}, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Revocations{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Revocations{}, fmt.Errorf("%w: status=%d", ErrRevocationsBadStatus, resp.StatusCode)
+	}
+
+	var rv Revocations
+	if err := json.NewDecoder(resp.Body).Decode(&rv); err != nil {
+		return Revocations{}, err
+	}
+	return rv, nil
+}
+
+func (s *Server) accessRevoked(ctx context.Context, c Claims) (bool, error) {
+	var iat time.Time
+	if c.IssuedAt != nil {
+		iat = c.IssuedAt.Time
+	}
+	return s.Store.AccessTokenRevoked(ctx, c.ID, c.UserID, c.SessionID, iat)
+}
+
+func (s *Server) handleListRevocations(w http.ResponseWriter, r *http.Request) {
+	rv, err := s.Store.ListRevocations(r.Context(), time.Now().UTC())
+	if err != nil {
+		s.err("list revocations", err)
+		serverError(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	kit.WriteJSON(w, http.StatusOK, rv)
+}
+
+type revokeReq struct {
+	Token  string `json:"token"`
+	JTI    string `json:"jti"`
+	UserID string `json:"user_id"`
+}
+
+func (r revokeReq) targets() int {
+	n := 0
+	for _, v := range []string{r.Token, r.JTI, r.UserID} {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req revokeReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+	if req.targets() != 1 {
+		badRequest(w, r, "exactly one of token/jti/user_id required", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	var err error
+	switch {
+	case req.UserID != "":
+		err = s.Store.RevokeUserAccess(r.Context(), req.UserID, now)
+	case req.JTI != "":
+		err = s.Store.RevokeAccessToken(r.Context(), req.JTI, now.Add(revocationTTL))
+	default:
+		claims, perr := s.JWT.Parse(req.Token)
+		if perr != nil || claims.ID == "" || claims.ExpiresAt == nil {
+			badRequest(w, r, "invalid token", nil)
+			return
+		}
+		req.JTI = claims.ID
+		err = s.Store.RevokeAccessToken(r.Context(), claims.ID, claims.ExpiresAt.Time.Add(expLeeway))
+	}
+	if err != nil {
+		s.err("revoke", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("access revoked", zap.String("actor_id", p.UserID), zap.String("jti", req.JTI), zap.String("user_id", req.UserID))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// introspectResp follows RFC 7662; sid, role and email_verified are extensions.
+type introspectResp struct {
+	Active        bool   `json:"active"`
+	Scope         string `json:"scope,omitempty"`
+	ClientID      string `json:"client_id,omitempty"`
+	Username      string `json:"username,omitempty"`
+	TokenType     string `json:"token_type,omitempty"`
+	Exp           int64  `json:"exp,omitempty"`
+	Iat           int64  `json:"iat,omitempty"`
+	Sub           string `json:"sub,omitempty"`
+	Iss           string `json:"iss,omitempty"`
+	JTI           string `json:"jti,omitempty"`
+	SessionID     string `json:"sid,omitempty"`
+	Role          string `json:"role,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+func (s *Server) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		badRequest(w, r, "bad form", nil)
+		return
+	}
+
+	tok := r.PostForm.Get("token")
+	if tok == "" {
+		badRequest(w, r, "token required", nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	claims, err := s.JWT.Parse(tok)
+	if err != nil {
+		kit.WriteJSON(w, http.StatusOK, introspectResp{Active: false})
+		return
+	}
+
+	revoked, err := s.accessRevoked(r.Context(), claims)
+	if err != nil {
+		s.err("introspect revocation check", err)
+		serverError(w, r)
+		return
+	}
+	if revoked {
+		kit.WriteJSON(w, http.StatusOK, introspectResp{Active: false})
+		return
+	}
+
+	resp := introspectResp{
+		Active:        true,
+		Scope:         claims.Scope,
+		Username:      claims.Email,
+		TokenType:     "Bearer",
+		Exp:           claims.ExpiresAt.Unix(),
+		Sub:           claims.UserID,
+		Iss:           claims.Issuer,
+		JTI:           claims.ID,
+		SessionID:     claims.SessionID,
+		Role:          claims.Role,
+		EmailVerified: claims.EmailVerified,
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.Role == kit.RoleService {
+		resp.ClientID = claims.UserID
+	}
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
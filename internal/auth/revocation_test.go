@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type staticTokens string
+
+func (s staticTokens) Token(context.Context) (string, error) { return string(s), nil }
+
+func TestRevocationClient_DoesNotBlockOnSlowFetch(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer svc-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		<-release
+		_ = json.NewEncoder(w).Encode(Revocations{
+			Tokens:   []RevokedToken{{JTI: "jti-1"}},
+			Sessions: []RevokedSession{{SessionID: "sess-1"}},
+			Users:    []RevokedUser{{UserID: "u1", IssuedBefore: time.Unix(2000, 0)}},
+		})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewRevocationClient(srv.URL, staticTokens("svc-token"))
+	c.Poll = time.Hour
+
+	start := time.Now()
+	for range 10 {
+		if c.Revoked(Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1"}}) {
+			t.Fatalf("revoked before the list arrived")
+		}
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Revoked blocked for %v", d)
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	for c.list.Load() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("list never loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	iat := func(sec int64) *jwt.NumericDate { return jwt.NewNumericDate(time.Unix(sec, 0)) }
+	tests := []struct {
+		name   string
+		claims Claims
+		want   bool
+	}{
+		{"revoked jti", Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1"}}, true},
+		{"other jti", Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "jti-2"}}, false},
+		{"revoked session", Claims{SessionID: "sess-1"}, true},
+		{"user before cutoff", Claims{UserID: "u1", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: iat(1999)}}, true},
+		{"user after cutoff", Claims{UserID: "u1", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: iat(2000)}}, false},
+		{"user without iat", Claims{UserID: "u1"}, true},
+		{"other user", Claims{UserID: "u2", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: iat(1)}}, false},
+	}
+	for _, tt := range tests {
+		if got := c.Revoked(tt.claims); got != tt.want {
+			t.Errorf("%s: Revoked=%v want=%v", tt.name, got, tt.want)
+		}
+	}
+}
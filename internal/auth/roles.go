@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+type roleReq struct {
+	Role string `json:"role"`
+}
+
+type roleResp struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (s *Server) handleGrantRole(w http.ResponseWriter, r *http.Request) {
+	var req roleReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	role := strings.TrimSpace(req.Role)
+	if !kit.IsKnownRole(role) {
+		badRequest(w, r, "unknown role", map[string]any{"role": req.Role})
+		return
+	}
+
+	s.setRole(w, r, role)
+}
+
+func (s *Server) handleRevokeRole(w http.ResponseWriter, r *http.Request) {
+	s.setRole(w, r, kit.RoleUser)
+}
+
+func (s *Server) setRole(w http.ResponseWriter, r *http.Request, role string) {
+	id := chi.URLParam(r, "id")
+
+	if err := s.Store.SetRole(r.Context(), id, role); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "user not found", map[string]any{"id": id})
+			return
+		}
+		s.err("set role", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("role changed", zap.String("actor_id", p.UserID), zap.String("user_id", id), zap.String("role", role))
+	}
+	s.audit(r, AuditRoleChanged, id, map[string]string{"role": role})
+
+	kit.WriteJSON(w, http.StatusOK, roleResp{UserID: id, Role: role})
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceTokenTimeout       = 3 * time.Second
+	serviceTokenRefreshBefore = 1 * time.Minute
+)
+
+var ErrServiceTokenBadStatus = errors.New("service token bad status")
+
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ServiceTokenSource reuses each token until shortly before it expires.
+type ServiceTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+
+	mu  sync.Mutex
+	tok string
+	exp time.Time
+}
+
+func NewServiceTokenSource(tokenURL, clientID, clientSecret string) *ServiceTokenSource {
+	return &ServiceTokenSource{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: serviceTokenTimeout},
+	}
+}
+
+// The lock is not held during the fetch; concurrent callers at expiry may
+// each fetch once.
+func (s *ServiceTokenSource) Token(ctx context.Context) (string, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	tok, exp := s.tok, s.exp
+	s.mu.Unlock()
+	if tok != "" && now.Before(exp) {
+		return tok, nil
+	}
+
+	tok, ttl, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.tok, s.exp = tok, now.Add(ttl-serviceTokenRefreshBefore)
+	s.mu.Unlock()
+	return tok, nil
+}
+
+func (s *ServiceTokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, serviceTokenTimeout)
+	defer cancel()
+
+	form := url.Values{"grant_type": {grantClientCredentials}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.ClientID, s.ClientSecret)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%w: status=%d", ErrServiceTokenBadStatus, resp.StatusCode)
+	}
+
+	var out clientTokenResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", 0, err
+	}
+	if out.AccessToken == "" {
+		return "", 0, fmt.Errorf("%w: empty access_token", ErrServiceTokenBadStatus)
+	}
+	return out.AccessToken, time.Duration(out.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"MiniStore/pkg/kit"
+)
+
+const maxUserAgentLen = 512
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session IDs double as refresh token family IDs and the sid claim.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+type sessionResp struct {
+	Session
+	Current bool `json:"current"`
+}
+
+func newSession(r *http.Request, userID string, now time.Time) Session {
+	return Session{
+		ID:         "ses_" + uuid.NewString(),
+		UserID:     userID,
+		UserAgent:  clientUserAgent(r),
+		IP:         kit.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+}
+
+func clientUserAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLen {
+		ua = ua[:maxUserAgentLen]
+	}
+	return ua
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	items, err := s.Store.ListSessions(r.Context(), claims.UserID, time.Now().UTC())
+	if err != nil {
+		s.err("list sessions", err)
+		serverError(w, r)
+		return
+	}
+
+	out := make([]sessionResp, 0, len(items))
+	for _, sess := range items {
+		out = append(out, sessionResp{Session: sess, Current: sess.ID == claims.SessionID})
+	}
+	kit.WriteJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	if err := s.Store.RevokeSession(r.Context(), claims.UserID, id, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "session not found", map[string]any{"id": id})
+			return
+		}
+		s.err("revoke session", err)
+		serverError(w, r)
+		return
+	}
+
+	s.audit(r, AuditSessionRevoked, claims.UserID, map[string]string{"session_id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,22 +3,100 @@ package auth
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
 	ErrEmailExists        = errors.New("email already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDisabled       = errors.New("user disabled")
 )
 
 type User struct {
-	ID    string
-	Email string
-	Hash  []byte
-	Role  string
+	ID            string
+	Email         string
+	Hash          []byte
+	Role          string
+	DisplayName   string
+	EmailVerified bool
+	CreatedAt     time.Time
+
+	FailedLogins int
+	LockedUntil  *time.Time
+	DisabledAt   *time.Time
+}
+
+func (u User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Changing the email clears its verified state.
+type ProfileUpdate struct {
+	DisplayName *string
+	Email       *string
 }
 
 type UserStore interface {
 	Create(ctx context.Context, email, password, role, id string) error
 	Verify(ctx context.Context, email, password string) (User, error)
+	GetByID(ctx context.Context, id string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	SetRole(ctx context.Context, id, role string) error
+	MarkEmailVerified(ctx context.Context, id string, at time.Time) error
+	UpdateProfile(ctx context.Context, id string, upd ProfileUpdate) (User, error)
+	ChangePassword(ctx context.Context, id, password string, now time.Time) error
+	DeleteUser(ctx context.Context, id string, now time.Time) error
+
+	ListUsers(ctx context.Context, q UserQuery) ([]User, error)
+	SetUserDisabled(ctx context.Context, id string, disabled bool, now time.Time) error
+	ForcePasswordReset(ctx context.Context, id string, now time.Time) error
+	EraseUser(ctx context.Context, id string, now time.Time) error
+
+	RecordLoginFailure(ctx context.Context, id string, now time.Time) (int, error)
+	LockUser(ctx context.Context, id string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, id string) error
+
+	GetTOTP(ctx context.Context, userID string) (TOTP, error)
+	SetPendingTOTP(ctx context.Context, userID, secret string) error
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string, now time.Time) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, hash string, now time.Time) error
+	DeleteTOTP(ctx context.Context, userID string) error
 	Ping(ctx context.Context) error
+
+	CreateRefreshToken(ctx context.Context, t RefreshToken) error
+	RotateRefreshToken(ctx context.Context, oldHash, newHash, clientID string, now, expiresAt time.Time) (RefreshToken, error)
+	RevokeRefreshFamily(ctx context.Context, hash string, now time.Time) error
+
+	CreateSession(ctx context.Context, sess Session) error
+	TouchSession(ctx context.Context, id, ip, userAgent string, now time.Time) error
+	ListSessions(ctx context.Context, userID string, now time.Time) ([]Session, error)
+	ListSessionHistory(ctx context.Context, userID string) ([]Session, error)
+	RevokeSession(ctx context.Context, userID, id string, now time.Time) error
+
+	CreateOAuthClient(ctx context.Context, c OAuthClient) error
+	GetOAuthClient(ctx context.Context, id string) (OAuthClient, error)
+	ListOAuthClients(ctx context.Context) ([]OAuthClient, error)
+	CreateAuthCode(ctx context.Context, ac AuthCode) error
+	ConsumeAuthCode(ctx context.Context, hash string, now time.Time) (AuthCode, error)
+
+	CreatePasswordReset(ctx context.Context, pr PasswordReset) error
+	ResetPassword(ctx context.Context, hash, password string, now time.Time) (string, error)
+
+	CreateServiceAccount(ctx context.Context, sa ServiceAccount) error
+	ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error)
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, id string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, now time.Time) error
+	RevokeAPIKey(ctx context.Context, accountID, id string, now time.Time) error
+
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserAccess(ctx context.Context, userID string, now time.Time) error
+	AccessTokenRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error)
+	ListRevocations(ctx context.Context, now time.Time) (Revocations, error)
+
+	AppendAudit(ctx context.Context, e AuditEvent) error
+	ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, error)
 }
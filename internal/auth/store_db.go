@@ -3,25 +3,44 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
-	"golang.org/x/crypto/bcrypt"
+
+	"MiniStore/pkg/kit"
 )
 
 const (
 	pingTimeout  = 1 * time.Second
 	queryTimeout = 3 * time.Second
 	pgUniqueCode = "23505"
+	pgFKeyCode   = "23503"
 )
 
+const userColumns = `id, email, pass_hash, role, display_name, email_verified_at IS NOT NULL,
+	failed_logins, locked_until, disabled_at, created_at`
+
+func userFields(u *User) []any {
+	return []any{&u.ID, &u.Email, &u.Hash, &u.Role, &u.DisplayName, &u.EmailVerified,
+		&u.FailedLogins, &u.LockedUntil, &u.DisabledAt, &u.CreatedAt}
+}
+
 type PostgresStore struct {
-	db *sql.DB
+	db     *sql.DB
+	hasher PasswordHasher
 }
 
 func NewPostgresStore(db *sql.DB) *PostgresStore {
-	return &PostgresStore{db: db}
+	return &PostgresStore{db: db, hasher: NewArgon2idHasher()}
+}
+
+func (s *PostgresStore) WithHasher(h PasswordHasher) *PostgresStore {
+	s.hasher = h
+	return s
 }
 
 func (s *PostgresStore) Ping(ctx context.Context) error {
@@ -32,9 +51,8 @@ func (s *PostgresStore) Ping(ctx context.Context) error {
 
 func (s *PostgresStore) Create(ctx context.Context, email, password, role, id string) error {
 	email = normalizeEmail(email)
-	password = normalizePassword(password)
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := hashPassword(s.hasher, password)
 	if err != nil {
 		return err
 	}
@@ -62,32 +80,1487 @@ func (s *PostgresStore) Verify(ctx context.Context, email, password string) (Use
 	var u User
 	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
 		return s.db.QueryRowContext(ctx, `
-			SELECT id, email, pass_hash, role
+			SELECT `+userColumns+`
 			FROM users
 			WHERE email = $1
-		`, email).Scan(&u.ID, &u.Email, &u.Hash, &u.Role)
+		`, email).Scan(userFields(&u)...)
 	})
 	if err == sql.ErrNoRows {
+		compareDummyPassword(password)
 		return User{}, ErrInvalidCredentials
 	}
 	if err != nil {
 		return User{}, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword(u.Hash, []byte(password)); err != nil {
+	if len(u.Hash) == 0 {
+		// Cleared by an admin-forced reset; still spend the hashing time.
+		compareDummyPassword(password)
+		return User{}, ErrInvalidCredentials
+	}
+	if !checkPassword(u.Hash, password) {
 		return User{}, ErrInvalidCredentials
 	}
+	if u.Disabled() {
+		return User{}, ErrUserDisabled
+	}
 
+	if s.hasher.NeedsRehash(u.Hash) {
+		s.rehash(ctx, &u, password)
+	}
 	return u, nil
 }
 
-func withTimeout(parent context.Context, d time.Duration, fn func(ctx context.Context) error) error {
-	ctx, cancel := context.WithTimeout(parent, d)
-	defer cancel()
-	return fn(ctx)
+// rehash is best effort: the old hash stays valid and the next login retries.
+func (s *PostgresStore) rehash(ctx context.Context, u *User, password string) {
+	hash, err := hashPassword(s.hasher, password)
+	if err != nil {
+		return
+	}
+
+	err = withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE users
+			SET pass_hash = $3
+			WHERE id = $1 AND pass_hash = $2
+		`, u.ID, u.Hash, hash)
+		return err
+	})
+	if err == nil {
+		u.Hash = hash
+	}
 }
 
-func isUniqueViolation(err error) bool {
-	var pgErr *pgconn.PgError
-	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueCode
+func (s *PostgresStore) GetByID(ctx context.Context, id string) (User, error) {
+	var u User
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT `+userColumns+`
+			FROM users
+			WHERE id = $1
+		`, id).Scan(userFields(&u)...)
+	})
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *PostgresStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	var u User
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT `+userColumns+`
+			FROM users
+			WHERE email = $1
+		`, normalizeEmail(email)).Scan(userFields(&u)...)
+	})
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *PostgresStore) SetRole(ctx context.Context, id, role string) error {
+	return s.execUser(ctx, `
+		UPDATE users SET role = $2 WHERE id = $1
+	`, id, role)
+}
+
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, id string, at time.Time) error {
+	return s.execUser(ctx, `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, $2) WHERE id = $1
+	`, id, at)
+}
+
+func (s *PostgresStore) UpdateProfile(ctx context.Context, id string, upd ProfileUpdate) (User, error) {
+	var email any
+	if upd.Email != nil {
+		email = normalizeEmail(*upd.Email)
+	}
+
+	var u User
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			UPDATE users
+			SET display_name = COALESCE($2, display_name),
+			    email_verified_at = CASE WHEN $3::text IS NULL OR $3 = email THEN email_verified_at END,
+			    email = COALESCE($3, email)
+			WHERE id = $1
+			RETURNING `+userColumns, id, upd.DisplayName, email).Scan(userFields(&u)...)
+	})
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if isUniqueViolation(err) {
+		return User{}, ErrEmailExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *PostgresStore) ChangePassword(ctx context.Context, id, password string, now time.Time) error {
+	passHash, err := hashPassword(s.hasher, password)
+	if err != nil {
+		return err
+	}
+
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users
+			SET pass_hash = $2, failed_logins = 0, locked_until = NULL
+			WHERE id = $1
+		`, id, passHash)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+
+		if err := revokeUserTokens(ctx, tx, id, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, id string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+
+		if err := revokeUserAccess(ctx, tx, id, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) ListUsers(ctx context.Context, q UserQuery) ([]User, error) {
+	query, args := buildUserQuery(q)
+
+	out := []User{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u User
+			if err := rows.Scan(userFields(&u)...); err != nil {
+				return err
+			}
+			out = append(out, u)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func buildUserQuery(q UserQuery) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.EmailPrefix != "" {
+		where = append(where, "email LIKE "+arg(escapeLike(q.EmailPrefix)+"%")+` ESCAPE '\'`)
+	}
+	if q.Role != "" {
+		where = append(where, "role = "+arg(q.Role))
+	}
+	if q.Disabled != nil {
+		if *q.Disabled {
+			where = append(where, "disabled_at IS NOT NULL")
+		} else {
+			where = append(where, "disabled_at IS NULL")
+		}
+	}
+	if q.CreatedAfter != nil {
+		where = append(where, "created_at >= "+arg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, "created_at < "+arg(*q.CreatedBefore))
+	}
+	if q.After != nil {
+		where = append(where, "(created_at, id) < ("+arg(q.After.CreatedAt)+", "+arg(q.After.ID)+")")
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT " + userColumns + " FROM users")
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString(" ORDER BY created_at DESC, id DESC")
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(q.Limit))
+	}
+
+	return b.String(), args
+}
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *PostgresStore) SetUserDisabled(ctx context.Context, id string, disabled bool, now time.Time) error {
+	if !disabled {
+		return s.execUser(ctx, `
+			UPDATE users SET disabled_at = NULL WHERE id = $1
+		`, id)
+	}
+
+	return s.updateAndRevoke(ctx, id, now, `
+		UPDATE users SET disabled_at = COALESCE(disabled_at, $2) WHERE id = $1
+	`, id, now)
+}
+
+func (s *PostgresStore) ForcePasswordReset(ctx context.Context, id string, now time.Time) error {
+	return s.updateAndRevoke(ctx, id, now, `
+		UPDATE users SET pass_hash = ''::bytea WHERE id = $1
+	`, id)
+}
+
+func (s *PostgresStore) updateAndRevoke(ctx context.Context, id string, now time.Time, query string, args ...any) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+
+		if err := revokeUserTokens(ctx, tx, id, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+// EraseUser keeps the row so that user_id references elsewhere stay valid.
+func (s *PostgresStore) EraseUser(ctx context.Context, id string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users
+			SET email = $2, display_name = '', pass_hash = ''::bytea, role = $3,
+			    email_verified_at = NULL, failed_logins = 0, last_failed_login_at = NULL,
+			    locked_until = NULL, disabled_at = COALESCE(disabled_at, $4)
+			WHERE id = $1
+		`, id, erasedEmail(id), kit.RoleUser, now)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+
+		for _, table := range []string{
+			"sessions", "refresh_tokens", "password_resets", "oauth_codes", "mfa_recovery_codes", "user_totp",
+		} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, id); err != nil {
+				return err
+			}
+		}
+
+		// Events where an admin acted on the user describe the admin's
+		// client and stay intact.
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE audit_events SET ip = '', user_agent = ''
+			WHERE ((user_id = $1 AND actor_id IN ('', $1)) OR actor_id = $1)
+			  AND (ip <> '' OR user_agent <> '')
+		`, id); err != nil {
+			return err
+		}
+
+		if err := revokeUserAccess(ctx, tx, id, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) RecordLoginFailure(ctx context.Context, id string, now time.Time) (int, error) {
+	var n int
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			UPDATE users
+			SET failed_logins = failed_logins + 1, last_failed_login_at = $2
+			WHERE id = $1
+			RETURNING failed_logins
+		`, id, now).Scan(&n)
+	})
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return n, err
+}
+
+func (s *PostgresStore) LockUser(ctx context.Context, id string, until time.Time) error {
+	return s.execUser(ctx, `
+		UPDATE users SET locked_until = $2 WHERE id = $1
+	`, id, until)
+}
+
+func (s *PostgresStore) ClearLoginFailures(ctx context.Context, id string) error {
+	return s.execUser(ctx, `
+		UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1
+	`, id)
+}
+
+func (s *PostgresStore) execUser(ctx context.Context, query string, args ...any) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) CreateRefreshToken(ctx context.Context, t RefreshToken) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO refresh_tokens (token_hash, family_id, user_id, client_id, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, t.Hash, t.FamilyID, t.UserID, t.ClientID, t.CreatedAt, t.ExpiresAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) RotateRefreshToken(ctx context.Context, oldHash, newHash, clientID string, now, expiresAt time.Time) (RefreshToken, error) {
+	var next RefreshToken
+
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		var old RefreshToken
+		err = tx.QueryRowContext(ctx, `
+			SELECT token_hash, family_id, user_id, client_id, created_at, expires_at, used_at, revoked_at
+			FROM refresh_tokens
+			WHERE token_hash = $1
+			FOR UPDATE
+		`, oldHash).Scan(&old.Hash, &old.FamilyID, &old.UserID, &old.ClientID, &old.CreatedAt, &old.ExpiresAt, &old.UsedAt, &old.RevokedAt)
+		if err == sql.ErrNoRows {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		if rerr := checkRotatable(old, clientID, now); rerr != nil {
+			if rerr != ErrRefreshTokenReused {
+				return rerr
+			}
+			if err := revokeFamily(ctx, tx, old.FamilyID, now); err != nil {
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			committed = true
+			return rerr
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1
+		`, oldHash, now); err != nil {
+			return err
+		}
+
+		next = RefreshToken{
+			Hash:      newHash,
+			FamilyID:  old.FamilyID,
+			UserID:    old.UserID,
+			ClientID:  old.ClientID,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO refresh_tokens (token_hash, family_id, user_id, client_id, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, next.Hash, next.FamilyID, next.UserID, next.ClientID, next.CreatedAt, next.ExpiresAt); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return next, nil
+}
+
+func (s *PostgresStore) RevokeRefreshFamily(ctx context.Context, hash string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		var familyID string
+		err = tx.QueryRowContext(ctx, `
+			SELECT family_id FROM refresh_tokens WHERE token_hash = $1
+		`, hash).Scan(&familyID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := revokeFamily(ctx, tx, familyID, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) CreateSession(ctx context.Context, sess Session) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, sess.ID, sess.UserID, sess.UserAgent, sess.IP, sess.CreatedAt, sess.LastSeenAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) TouchSession(ctx context.Context, id, ip, userAgent string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE sessions
+			SET ip = $2, user_agent = $3, last_seen_at = $4
+			WHERE id = $1 AND revoked_at IS NULL
+		`, id, ip, userAgent, now)
+		return err
+	})
+}
+
+func (s *PostgresStore) ListSessions(ctx context.Context, userID string, now time.Time) ([]Session, error) {
+	out := []Session{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+			FROM sessions
+			WHERE user_id = $1 AND revoked_at IS NULL AND last_seen_at > $2
+			ORDER BY last_seen_at DESC, id
+		`, userID, now.Add(-refreshTokenTTL))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sess Session
+			if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP,
+				&sess.CreatedAt, &sess.LastSeenAt, &sess.RevokedAt); err != nil {
+				return err
+			}
+			out = append(out, sess)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) ListSessionHistory(ctx context.Context, userID string) ([]Session, error) {
+	out := []Session{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+			FROM sessions
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sess Session
+			if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP,
+				&sess.CreatedAt, &sess.LastSeenAt, &sess.RevokedAt); err != nil {
+				return err
+			}
+			out = append(out, sess)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) RevokeSession(ctx context.Context, userID, id string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE sessions
+			SET revoked_at = $3
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		`, id, userID, now)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrSessionNotFound
+		}
+
+		if err := revokeFamily(ctx, tx, id, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) CreatePasswordReset(ctx context.Context, pr PasswordReset) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO password_resets (token_hash, user_id, created_at, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`, pr.Hash, pr.UserID, pr.CreatedAt, pr.ExpiresAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) ResetPassword(ctx context.Context, hash, password string, now time.Time) (string, error) {
+	passHash, err := hashPassword(s.hasher, password)
+	if err != nil {
+		return "", err
+	}
+
+	var userID string
+	err = withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		var pr PasswordReset
+		err = tx.QueryRowContext(ctx, `
+			SELECT token_hash, user_id, created_at, expires_at, used_at
+			FROM password_resets
+			WHERE token_hash = $1
+			FOR UPDATE
+		`, hash).Scan(&pr.Hash, &pr.UserID, &pr.CreatedAt, &pr.ExpiresAt, &pr.UsedAt)
+		if err == sql.ErrNoRows {
+			return ErrResetTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkResettable(pr, now); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users
+			SET pass_hash = $2, failed_logins = 0, locked_until = NULL
+			WHERE id = $1
+		`, pr.UserID, passHash); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE password_resets
+			SET used_at = $2
+			WHERE user_id = $1 AND used_at IS NULL
+		`, pr.UserID, now); err != nil {
+			return err
+		}
+
+		if err := revokeUserTokens(ctx, tx, pr.UserID, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		userID = pr.UserID
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (s *PostgresStore) GetTOTP(ctx context.Context, userID string) (TOTP, error) {
+	t := TOTP{UserID: userID}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT secret, confirmed_at, last_step
+			FROM user_totp
+			WHERE user_id = $1
+		`, userID).Scan(&t.Secret, &t.ConfirmedAt, &t.LastStep)
+	})
+	if err == sql.ErrNoRows {
+		return TOTP{}, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return TOTP{}, err
+	}
+	return t, nil
+}
+
+func (s *PostgresStore) SetPendingTOTP(ctx context.Context, userID, secret string) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, `
+			INSERT INTO user_totp (user_id, secret)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_step = 0
+			WHERE user_totp.confirmed_at IS NULL
+		`, userID, secret)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFAAlreadyEnabled
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) EnableTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE user_totp
+			SET confirmed_at = $2, last_step = $3
+			WHERE user_id = $1 AND confirmed_at IS NULL
+		`, userID, now, step)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFAAlreadyEnabled
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM mfa_recovery_codes WHERE user_id = $1
+		`, userID); err != nil {
+			return err
+		}
+		for _, h := range recoveryHashes {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO mfa_recovery_codes (code_hash, user_id, created_at)
+				VALUES ($1, $2, $3)
+			`, h, userID, now); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE user_totp
+			SET last_step = $2
+			WHERE user_id = $1 AND last_step < $2
+		`, userID, step)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFACodeReused
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, userID, hash string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE mfa_recovery_codes
+			SET used_at = $3
+			WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL
+		`, hash, userID, now)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFACodeInvalid
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) DeleteTOTP(ctx context.Context, userID string) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) CreateServiceAccount(ctx context.Context, sa ServiceAccount) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO service_accounts (id, name, created_at)
+			VALUES ($1, $2, $3)
+		`, sa.ID, sa.Name, sa.CreatedAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	items := []ServiceAccount{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, name, created_at
+			FROM service_accounts
+			ORDER BY created_at, id
+		`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sa ServiceAccount
+			if err := rows.Scan(&sa.ID, &sa.Name, &sa.CreatedAt); err != nil {
+				return err
+			}
+			items = append(items, sa)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const apiKeyColumns = `id, service_account_id, key_hash, scopes, created_at, last_used_at, revoked_at`
+
+func apiKeyFields(k *APIKey, scopes *string) []any {
+	return []any{&k.ID, &k.AccountID, &k.Hash, scopes, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt}
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, k APIKey) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO api_keys (id, service_account_id, key_hash, scopes, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, k.ID, k.AccountID, k.Hash, strings.Join(k.Scopes, " "), k.CreatedAt)
+		if isForeignKeyViolation(err) {
+			return ErrServiceAccountNotFound
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	var (
+		k      APIKey
+		scopes string
+	)
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT `+apiKeyColumns+`
+			FROM api_keys
+			WHERE id = $1
+		`, id).Scan(apiKeyFields(&k, &scopes)...)
+	})
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	return k, nil
+}
+
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	items := []APIKey{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM service_accounts WHERE id = $1)
+		`, accountID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrServiceAccountNotFound
+		}
+
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+apiKeyColumns+`
+			FROM api_keys
+			WHERE service_account_id = $1
+			ORDER BY created_at, id
+		`, accountID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				k      APIKey
+				scopes string
+			)
+			if err := rows.Scan(apiKeyFields(&k, &scopes)...); err != nil {
+				return err
+			}
+			k.Scopes = strings.Fields(scopes)
+			items = append(items, k)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *PostgresStore) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE api_keys
+			SET last_used_at = $2
+			WHERE id = $1
+		`, id, now)
+		return err
+	})
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, accountID, id string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE api_keys
+			SET revoked_at = COALESCE(revoked_at, $3)
+			WHERE id = $1 AND service_account_id = $2
+		`, id, accountID, now)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrAPIKeyNotFound
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO access_revocations_tokens (jti, expires_at)
+			VALUES ($1, $2)
+			ON CONFLICT (jti) DO NOTHING
+		`, jti, expiresAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) RevokeUserAccess(ctx context.Context, userID string, now time.Time) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		if err := revokeUserTokens(ctx, tx, userID, now); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+}
+
+func (s *PostgresStore) AccessTokenRevoked(ctx context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM access_revocations_tokens WHERE jti = $1 AND $1 <> '')
+				OR EXISTS (SELECT 1 FROM access_revocations_users WHERE user_id = $2 AND issued_before > $3)
+				OR EXISTS (SELECT 1 FROM sessions WHERE id = $4 AND $4 <> '' AND revoked_at IS NOT NULL)
+		`, jti, userID, issuedAt, sessionID).Scan(&revoked)
+	})
+	return revoked, err
+}
+
+func (s *PostgresStore) ListRevocations(ctx context.Context, now time.Time) (Revocations, error) {
+	rv := Revocations{Tokens: []RevokedToken{}, Users: []RevokedUser{}, Sessions: []RevokedSession{}}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT jti, expires_at
+			FROM access_revocations_tokens
+			WHERE expires_at > $1
+		`, now)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t RevokedToken
+			if err := rows.Scan(&t.JTI, &t.ExpiresAt); err != nil {
+				return err
+			}
+			rv.Tokens = append(rv.Tokens, t)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		urows, err := s.db.QueryContext(ctx, `
+			SELECT user_id, issued_before
+			FROM access_revocations_users
+			WHERE issued_before > $1
+		`, now.Add(-revocationTTL))
+		if err != nil {
+			return err
+		}
+		defer urows.Close()
+
+		for urows.Next() {
+			var u RevokedUser
+			if err := urows.Scan(&u.UserID, &u.IssuedBefore); err != nil {
+				return err
+			}
+			rv.Users = append(rv.Users, u)
+		}
+		if err := urows.Err(); err != nil {
+			return err
+		}
+
+		srows, err := s.db.QueryContext(ctx, `
+			SELECT id, revoked_at
+			FROM sessions
+			WHERE revoked_at > $1
+		`, now.Add(-revocationTTL))
+		if err != nil {
+			return err
+		}
+		defer srows.Close()
+
+		for srows.Next() {
+			var sess RevokedSession
+			if err := srows.Scan(&sess.SessionID, &sess.RevokedAt); err != nil {
+				return err
+			}
+			rv.Sessions = append(rv.Sessions, sess)
+		}
+		return srows.Err()
+	})
+	if err != nil {
+		return Revocations{}, err
+	}
+	return rv, nil
+}
+
+func revokeUserTokens(ctx context.Context, tx *sql.Tx, userID string, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, now); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, now); err != nil {
+		return err
+	}
+	return revokeUserAccess(ctx, tx, userID, now)
+}
+
+func revokeUserAccess(ctx context.Context, tx *sql.Tx, userID string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO access_revocations_users (user_id, issued_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET issued_before = GREATEST(access_revocations_users.issued_before, EXCLUDED.issued_before)
+	`, userID, userCutoff(now))
+	return err
+}
+
+// revokeFamily also ends the session the family belongs to, if any.
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID, now); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`, familyID, now)
+	return err
+}
+
+func withTimeout(parent context.Context, d time.Duration, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(parent, d)
+	defer cancel()
+	return fn(ctx)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueCode
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgFKeyCode
+}
+
+func (s *PostgresStore) AppendAudit(ctx context.Context, e AuditEvent) error {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO audit_events (type, user_id, actor_id, ip, user_agent, request_id, details, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, e.Type, e.UserID, e.ActorID, e.IP, e.UserAgent, e.RequestID, details, e.CreatedAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	query, args := buildAuditQuery(q)
+
+	out := []AuditEvent{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				e       AuditEvent
+				details []byte
+			)
+			if err := rows.Scan(&e.ID, &e.Type, &e.UserID, &e.ActorID, &e.IP, &e.UserAgent,
+				&e.RequestID, &details, &e.CreatedAt); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(details, &e.Details); err != nil {
+				return err
+			}
+			if len(e.Details) == 0 {
+				e.Details = nil
+			}
+			out = append(out, e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func buildAuditQuery(q AuditQuery) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.UserID != "" {
+		where = append(where, "user_id = "+arg(q.UserID))
+	}
+	if q.Type != "" {
+		where = append(where, "type = "+arg(q.Type))
+	}
+	if q.Since != nil {
+		where = append(where, "created_at >= "+arg(*q.Since))
+	}
+	if q.Until != nil {
+		where = append(where, "created_at < "+arg(*q.Until))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < "+arg(q.BeforeID))
+	}
+
+	var b strings.Builder
+	b.WriteString(`SELECT id, type, user_id, actor_id, ip, user_agent, request_id, details, created_at
+		FROM audit_events`)
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString(" ORDER BY id DESC")
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(q.Limit))
+	}
+
+	return b.String(), args
+}
+
+const oauthClientColumns = `id, name, secret_hash, redirect_uris, created_at`
+
+func oauthClientFields(c *OAuthClient, uris *string) []any {
+	return []any{&c.ID, &c.Name, &c.SecretHash, uris, &c.CreatedAt}
+}
+
+func (s *PostgresStore) CreateOAuthClient(ctx context.Context, c OAuthClient) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, c.ID, c.Name, c.SecretHash, strings.Join(c.RedirectURIs, " "), c.CreatedAt)
+		return err
+	})
+}
+
+func (s *PostgresStore) GetOAuthClient(ctx context.Context, id string) (OAuthClient, error) {
+	var (
+		c    OAuthClient
+		uris string
+	)
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT `+oauthClientColumns+`
+			FROM oauth_clients
+			WHERE id = $1
+		`, id).Scan(oauthClientFields(&c, &uris)...)
+	})
+	if err == sql.ErrNoRows {
+		return OAuthClient{}, ErrClientNotFound
+	}
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	c.RedirectURIs = strings.Fields(uris)
+	c.Confidential = c.SecretHash != ""
+	return c, nil
+}
+
+func (s *PostgresStore) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	items := []OAuthClient{}
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+oauthClientColumns+`
+			FROM oauth_clients
+			ORDER BY created_at, id
+		`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				c    OAuthClient
+				uris string
+			)
+			if err := rows.Scan(oauthClientFields(&c, &uris)...); err != nil {
+				return err
+			}
+			c.RedirectURIs = strings.Fields(uris)
+			c.Confidential = c.SecretHash != ""
+			items = append(items, c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *PostgresStore) CreateAuthCode(ctx context.Context, ac AuthCode) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+				user_agent, ip, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, ac.Hash, ac.ClientID, ac.UserID, ac.RedirectURI, ac.Scope, ac.Nonce, ac.CodeChallenge,
+			ac.UserAgent, ac.IP, ac.CreatedAt, ac.ExpiresAt)
+		return err
+	})
+}
+
+// ConsumeAuthCode marks the code used in the same statement that reads it,
+// so two concurrent exchanges cannot both succeed.
+func (s *PostgresStore) ConsumeAuthCode(ctx context.Context, hash string, now time.Time) (AuthCode, error) {
+	var ac AuthCode
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			UPDATE oauth_codes
+			SET used_at = $2
+			WHERE code_hash = $1 AND used_at IS NULL AND expires_at > $2
+			RETURNING code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+				user_agent, ip, created_at, expires_at, used_at
+		`, hash, now).Scan(&ac.Hash, &ac.ClientID, &ac.UserID, &ac.RedirectURI, &ac.Scope, &ac.Nonce,
+			&ac.CodeChallenge, &ac.UserAgent, &ac.IP, &ac.CreatedAt, &ac.ExpiresAt, &ac.UsedAt)
+	})
+	if err == sql.ErrNoRows {
+		return AuthCode{}, ErrAuthCodeInvalid
+	}
+	if err != nil {
+		return AuthCode{}, err
+	}
+	return ac, nil
 }
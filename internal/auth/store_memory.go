@@ -1,30 +1,81 @@
 package auth
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"MiniStore/pkg/kit"
 )
 
 type MemStore struct {
 	mu      sync.RWMutex
+	hasher  PasswordHasher
 	byEmail map[string]User
+	refresh map[string]RefreshToken
+	session map[string]Session
+	resets  map[string]PasswordReset
+	totp    map[string]TOTP
+	recover map[string]recoveryCode
+	svc     map[string]ServiceAccount
+	keys    map[string]APIKey
+	clients map[string]OAuthClient
+	codes   map[string]AuthCode
+
+	revokedJTI map[string]time.Time
+	cutoffs    map[string]time.Time
+
+	audit []AuditEvent
+}
+
+type recoveryCode struct {
+	userID string
+	usedAt *time.Time
 }
 
 func NewMemStore() *MemStore {
 	return &MemStore{
+		hasher:  NewArgon2idHasher(),
 		byEmail: make(map[string]User),
+		refresh: make(map[string]RefreshToken),
+		session: make(map[string]Session),
+		resets:  make(map[string]PasswordReset),
+		totp:    make(map[string]TOTP),
+		recover: make(map[string]recoveryCode),
+		svc:     make(map[string]ServiceAccount),
+		keys:    make(map[string]APIKey),
+		clients: make(map[string]OAuthClient),
+		codes:   make(map[string]AuthCode),
+
+		revokedJTI: make(map[string]time.Time),
+		cutoffs:    make(map[string]time.Time),
 	}
 }
 
+func (s *MemStore) WithHasher(h PasswordHasher) *MemStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hasher = h
+	return s
+}
+
+func (s *MemStore) passwordHasher() PasswordHasher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hasher
+}
+
 func (s *MemStore) Ping(context.Context) error { return nil }
 
 func (s *MemStore) Create(_ context.Context, email, password, role, id string) error {
 	email = normalizeEmail(email)
-	password = normalizePassword(password)
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := hashPassword(s.passwordHasher(), password)
 	if err != nil {
 		return err
 	}
@@ -37,10 +88,11 @@ func (s *MemStore) Create(_ context.Context, email, password, role, id string) e
 	}
 
 	s.byEmail[email] = User{
-		ID:    id,
-		Email: email,
-		Hash:  hash,
-		Role:  role,
+		ID:        id,
+		Email:     email,
+		Hash:      hash,
+		Role:      role,
+		CreatedAt: time.Now().UTC(),
 	}
 	return nil
 }
@@ -54,10 +106,792 @@ func (s *MemStore) Verify(_ context.Context, email, password string) (User, erro
 	s.mu.RUnlock()
 
 	if !ok {
+		compareDummyPassword(password)
 		return User{}, ErrInvalidCredentials
 	}
-	if err := bcrypt.CompareHashAndPassword(u.Hash, []byte(password)); err != nil {
+	if len(u.Hash) == 0 {
+		// Cleared by an admin-forced reset; still spend the hashing time.
+		compareDummyPassword(password)
 		return User{}, ErrInvalidCredentials
 	}
+	if !checkPassword(u.Hash, password) {
+		return User{}, ErrInvalidCredentials
+	}
+	if u.Disabled() {
+		return User{}, ErrUserDisabled
+	}
+
+	if h := s.passwordHasher(); h.NeedsRehash(u.Hash) {
+		if hash, err := hashPassword(h, password); err == nil {
+			s.mu.Lock()
+			if cur, ok := s.byEmail[email]; ok && bytes.Equal(cur.Hash, u.Hash) {
+				cur.Hash = hash
+				s.byEmail[email] = cur
+				u.Hash = hash
+			}
+			s.mu.Unlock()
+		}
+	}
 	return u, nil
 }
+
+func (s *MemStore) GetByID(_ context.Context, id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.byEmail {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemStore) GetByEmail(_ context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.byEmail[normalizeEmail(email)]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *MemStore) SetRole(_ context.Context, id, role string) error {
+	return s.updateUser(id, func(u *User) { u.Role = role })
+}
+
+func (s *MemStore) MarkEmailVerified(_ context.Context, id string, _ time.Time) error {
+	return s.updateUser(id, func(u *User) { u.EmailVerified = true })
+}
+
+func (s *MemStore) UpdateProfile(_ context.Context, id string, upd ProfileUpdate) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, u := range s.byEmail {
+		if u.ID != id {
+			continue
+		}
+
+		if upd.DisplayName != nil {
+			u.DisplayName = *upd.DisplayName
+		}
+		if upd.Email != nil {
+			next := normalizeEmail(*upd.Email)
+			if next != email {
+				if _, taken := s.byEmail[next]; taken {
+					return User{}, ErrEmailExists
+				}
+				delete(s.byEmail, email)
+				u.Email = next
+				u.EmailVerified = false
+			}
+		}
+
+		s.byEmail[u.Email] = u
+		return u, nil
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemStore) ChangePassword(_ context.Context, id, password string, now time.Time) error {
+	passHash, err := hashPassword(s.passwordHasher(), password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.updateUser(id, func(u *User) {
+		u.Hash = passHash
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revokeUserLocked(id, now)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) DeleteUser(_ context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, u := range s.byEmail {
+		if u.ID == id {
+			delete(s.byEmail, email)
+			delete(s.totp, id)
+			s.cutoffLocked(id, now)
+			s.dropRecoveryLocked(id)
+			for h, t := range s.refresh {
+				if t.UserID == id {
+					delete(s.refresh, h)
+				}
+			}
+			for sid, sess := range s.session {
+				if sess.UserID == id {
+					delete(s.session, sid)
+				}
+			}
+			for h, ac := range s.codes {
+				if ac.UserID == id {
+					delete(s.codes, h)
+				}
+			}
+			for h, pr := range s.resets {
+				if pr.UserID == id {
+					delete(s.resets, h)
+				}
+			}
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (s *MemStore) RecordLoginFailure(_ context.Context, id string, _ time.Time) (int, error) {
+	var n int
+	err := s.updateUser(id, func(u *User) {
+		u.FailedLogins++
+		n = u.FailedLogins
+	})
+	return n, err
+}
+
+func (s *MemStore) LockUser(_ context.Context, id string, until time.Time) error {
+	return s.updateUser(id, func(u *User) { u.LockedUntil = &until })
+}
+
+func (s *MemStore) ClearLoginFailures(_ context.Context, id string) error {
+	return s.updateUser(id, func(u *User) {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+	})
+}
+
+func (s *MemStore) ListUsers(_ context.Context, q UserQuery) ([]User, error) {
+	s.mu.RLock()
+	out := []User{}
+	for _, u := range s.byEmail {
+		if q.matches(u) {
+			out = append(out, u)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(out, func(a, b User) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (s *MemStore) SetUserDisabled(_ context.Context, id string, disabled bool, now time.Time) error {
+	if !disabled {
+		return s.updateUser(id, func(u *User) { u.DisabledAt = nil })
+	}
+
+	if err := s.updateUser(id, func(u *User) {
+		if u.DisabledAt == nil {
+			u.DisabledAt = &now
+		}
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revokeUserLocked(id, now)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) ForcePasswordReset(_ context.Context, id string, now time.Time) error {
+	if err := s.updateUser(id, func(u *User) { u.Hash = []byte{} }); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revokeUserLocked(id, now)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) EraseUser(_ context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, u := range s.byEmail {
+		if u.ID != id {
+			continue
+		}
+
+		delete(s.byEmail, email)
+		u.Email = erasedEmail(id)
+		u.DisplayName = ""
+		u.Hash = []byte{}
+		u.Role = kit.RoleUser
+		u.EmailVerified = false
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		if u.DisabledAt == nil {
+			u.DisabledAt = &now
+		}
+		s.byEmail[u.Email] = u
+
+		delete(s.totp, id)
+		s.dropRecoveryLocked(id)
+		s.cutoffLocked(id, now)
+		for h, t := range s.refresh {
+			if t.UserID == id {
+				delete(s.refresh, h)
+			}
+		}
+		for sid, sess := range s.session {
+			if sess.UserID == id {
+				delete(s.session, sid)
+			}
+		}
+		for h, ac := range s.codes {
+			if ac.UserID == id {
+				delete(s.codes, h)
+			}
+		}
+		for h, pr := range s.resets {
+			if pr.UserID == id {
+				delete(s.resets, h)
+			}
+		}
+		for i, e := range s.audit {
+			if (e.UserID == id && (e.ActorID == "" || e.ActorID == id)) || e.ActorID == id {
+				s.audit[i].IP, s.audit[i].UserAgent = "", ""
+			}
+		}
+		return nil
+	}
+	return ErrUserNotFound
+}
+
+func (s *MemStore) updateUser(id string, fn func(u *User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, u := range s.byEmail {
+		if u.ID == id {
+			fn(&u)
+			s.byEmail[email] = u
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (s *MemStore) CreateRefreshToken(_ context.Context, t RefreshToken) error {
+	s.mu.Lock()
+	s.refresh[t.Hash] = t
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) RotateRefreshToken(_ context.Context, oldHash, newHash, clientID string, now, expiresAt time.Time) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.refresh[oldHash]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	if err := checkRotatable(old, clientID, now); err != nil {
+		if err == ErrRefreshTokenReused {
+			s.revokeFamilyLocked(old.FamilyID, now)
+		}
+		return RefreshToken{}, err
+	}
+
+	old.UsedAt = &now
+	s.refresh[oldHash] = old
+
+	next := RefreshToken{
+		Hash:      newHash,
+		FamilyID:  old.FamilyID,
+		UserID:    old.UserID,
+		ClientID:  old.ClientID,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	s.refresh[newHash] = next
+	return next, nil
+}
+
+func (s *MemStore) RevokeRefreshFamily(_ context.Context, hash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.refresh[hash]
+	if !ok {
+		return nil
+	}
+	s.revokeFamilyLocked(t.FamilyID, now)
+	return nil
+}
+
+func (s *MemStore) CreatePasswordReset(_ context.Context, pr PasswordReset) error {
+	s.mu.Lock()
+	s.resets[pr.Hash] = pr
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) ResetPassword(_ context.Context, hash, password string, now time.Time) (string, error) {
+	passHash, err := hashPassword(s.passwordHasher(), password)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pr, ok := s.resets[hash]
+	if !ok {
+		return "", ErrResetTokenInvalid
+	}
+	if err := checkResettable(pr, now); err != nil {
+		return "", err
+	}
+
+	for email, u := range s.byEmail {
+		if u.ID == pr.UserID {
+			u.Hash = passHash
+			u.FailedLogins = 0
+			u.LockedUntil = nil
+			s.byEmail[email] = u
+		}
+	}
+	for h, r := range s.resets {
+		if r.UserID == pr.UserID && r.UsedAt == nil {
+			r.UsedAt = &now
+			s.resets[h] = r
+		}
+	}
+	s.revokeUserLocked(pr.UserID, now)
+	return pr.UserID, nil
+}
+
+func (s *MemStore) revokeUserLocked(userID string, now time.Time) {
+	for h, t := range s.refresh {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			s.refresh[h] = t
+		}
+	}
+	for id, sess := range s.session {
+		if sess.UserID == userID && sess.RevokedAt == nil {
+			sess.RevokedAt = &now
+			s.session[id] = sess
+		}
+	}
+	s.cutoffLocked(userID, now)
+}
+
+func (s *MemStore) cutoffLocked(userID string, now time.Time) {
+	if cut := userCutoff(now); cut.After(s.cutoffs[userID]) {
+		s.cutoffs[userID] = cut
+	}
+}
+
+func (s *MemStore) revokeFamilyLocked(familyID string, now time.Time) {
+	for h, t := range s.refresh {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			s.refresh[h] = t
+		}
+	}
+	if sess, ok := s.session[familyID]; ok && sess.RevokedAt == nil {
+		sess.RevokedAt = &now
+		s.session[familyID] = sess
+	}
+}
+
+func (s *MemStore) CreateSession(_ context.Context, sess Session) error {
+	s.mu.Lock()
+	s.session[sess.ID] = sess
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) TouchSession(_ context.Context, id, ip, userAgent string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.session[id]; ok && sess.RevokedAt == nil {
+		sess.IP = ip
+		sess.UserAgent = userAgent
+		sess.LastSeenAt = now
+		s.session[id] = sess
+	}
+	return nil
+}
+
+func (s *MemStore) ListSessions(_ context.Context, userID string, now time.Time) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []Session{}
+	for _, sess := range s.session {
+		if sess.UserID == userID && sess.RevokedAt == nil && sess.LastSeenAt.After(now.Add(-refreshTokenTTL)) {
+			out = append(out, sess)
+		}
+	}
+	slices.SortFunc(out, func(a, b Session) int {
+		return cmp.Or(b.LastSeenAt.Compare(a.LastSeenAt), cmp.Compare(a.ID, b.ID))
+	})
+	return out, nil
+}
+
+func (s *MemStore) ListSessionHistory(_ context.Context, userID string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []Session{}
+	for _, sess := range s.session {
+		if sess.UserID == userID {
+			out = append(out, sess)
+		}
+	}
+	slices.SortFunc(out, func(a, b Session) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return out, nil
+}
+
+func (s *MemStore) RevokeSession(_ context.Context, userID, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.session[id]
+	if !ok || sess.UserID != userID || sess.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+	s.revokeFamilyLocked(id, now)
+	return nil
+}
+
+func (s *MemStore) GetTOTP(_ context.Context, userID string) (TOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.totp[userID]
+	if !ok {
+		return TOTP{}, ErrMFANotEnrolled
+	}
+	return t, nil
+}
+
+func (s *MemStore) SetPendingTOTP(_ context.Context, userID, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.totp[userID]; ok && t.ConfirmedAt != nil {
+		return ErrMFAAlreadyEnabled
+	}
+	s.totp[userID] = TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (s *MemStore) EnableTOTP(_ context.Context, userID string, step int64, recoveryHashes []string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[userID]
+	if !ok || t.ConfirmedAt != nil {
+		return ErrMFAAlreadyEnabled
+	}
+	t.ConfirmedAt = &now
+	t.LastStep = step
+	s.totp[userID] = t
+
+	s.dropRecoveryLocked(userID)
+	for _, h := range recoveryHashes {
+		s.recover[h] = recoveryCode{userID: userID}
+	}
+	return nil
+}
+
+func (s *MemStore) UseTOTPStep(_ context.Context, userID string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[userID]
+	if !ok || t.LastStep >= step {
+		return ErrMFACodeReused
+	}
+	t.LastStep = step
+	s.totp[userID] = t
+	return nil
+}
+
+func (s *MemStore) UseRecoveryCode(_ context.Context, userID, hash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rc, ok := s.recover[hash]
+	if !ok || rc.userID != userID || rc.usedAt != nil {
+		return ErrMFACodeInvalid
+	}
+	rc.usedAt = &now
+	s.recover[hash] = rc
+	return nil
+}
+
+func (s *MemStore) DeleteTOTP(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totp, userID)
+	s.dropRecoveryLocked(userID)
+	return nil
+}
+
+func (s *MemStore) dropRecoveryLocked(userID string) {
+	for h, rc := range s.recover {
+		if rc.userID == userID {
+			delete(s.recover, h)
+		}
+	}
+}
+
+func (s *MemStore) CreateServiceAccount(_ context.Context, sa ServiceAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.svc[sa.ID] = sa
+	return nil
+}
+
+func (s *MemStore) ListServiceAccounts(context.Context) ([]ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]ServiceAccount, 0, len(s.svc))
+	for _, sa := range s.svc {
+		items = append(items, sa)
+	}
+	slices.SortFunc(items, func(a, b ServiceAccount) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return items, nil
+}
+
+func (s *MemStore) CreateAPIKey(_ context.Context, k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.svc[k.AccountID]; !ok {
+		return ErrServiceAccountNotFound
+	}
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s *MemStore) GetAPIKey(_ context.Context, id string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return k, nil
+}
+
+func (s *MemStore) ListAPIKeys(_ context.Context, accountID string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.svc[accountID]; !ok {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	items := []APIKey{}
+	for _, k := range s.keys {
+		if k.AccountID == accountID {
+			items = append(items, k)
+		}
+	}
+	slices.SortFunc(items, func(a, b APIKey) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return items, nil
+}
+
+func (s *MemStore) TouchAPIKey(_ context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.keys[id]; ok {
+		k.LastUsedAt = &now
+		s.keys[id] = k
+	}
+	return nil
+}
+
+func (s *MemStore) RevokeAPIKey(_ context.Context, accountID, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok || k.AccountID != accountID {
+		return ErrAPIKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &now
+		s.keys[id] = k
+	}
+	return nil
+}
+
+func (s *MemStore) RevokeAccessToken(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedJTI[jti]; !ok {
+		s.revokedJTI[jti] = expiresAt
+	}
+	return nil
+}
+
+func (s *MemStore) RevokeUserAccess(_ context.Context, userID string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeUserLocked(userID, now)
+	return nil
+}
+
+func (s *MemStore) AccessTokenRevoked(_ context.Context, jti, userID, sessionID string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.revokedJTI[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if sess, ok := s.session[sessionID]; ok && sess.RevokedAt != nil {
+		return true, nil
+	}
+	cut, ok := s.cutoffs[userID]
+	return ok && issuedAt.Before(cut), nil
+}
+
+func (s *MemStore) ListRevocations(_ context.Context, now time.Time) (Revocations, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rv := Revocations{Tokens: []RevokedToken{}, Users: []RevokedUser{}, Sessions: []RevokedSession{}}
+	for jti, exp := range s.revokedJTI {
+		if exp.After(now) {
+			rv.Tokens = append(rv.Tokens, RevokedToken{JTI: jti, ExpiresAt: exp})
+		}
+	}
+	for id, cut := range s.cutoffs {
+		if cut.After(now.Add(-revocationTTL)) {
+			rv.Users = append(rv.Users, RevokedUser{UserID: id, IssuedBefore: cut})
+		}
+	}
+	for id, sess := range s.session {
+		if sess.RevokedAt != nil && sess.RevokedAt.After(now.Add(-revocationTTL)) {
+			rv.Sessions = append(rv.Sessions, RevokedSession{SessionID: id, RevokedAt: *sess.RevokedAt})
+		}
+	}
+	return rv, nil
+}
+
+func (s *MemStore) AppendAudit(_ context.Context, e AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = int64(len(s.audit)) + 1
+	e.Details = maps.Clone(e.Details)
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *MemStore) ListAudit(_ context.Context, q AuditQuery) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []AuditEvent{}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+		if e := s.audit[i]; q.matches(e) {
+			e.Details = maps.Clone(e.Details)
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemStore) CreateOAuthClient(_ context.Context, c OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.RedirectURIs = slices.Clone(c.RedirectURIs)
+	s.clients[c.ID] = c
+	return nil
+}
+
+func (s *MemStore) GetOAuthClient(_ context.Context, id string) (OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.clients[id]
+	if !ok {
+		return OAuthClient{}, ErrClientNotFound
+	}
+	c.RedirectURIs = slices.Clone(c.RedirectURIs)
+	return c, nil
+}
+
+func (s *MemStore) ListOAuthClients(_ context.Context) ([]OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]OAuthClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		c.RedirectURIs = slices.Clone(c.RedirectURIs)
+		items = append(items, c)
+	}
+	slices.SortFunc(items, func(a, b OAuthClient) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return items, nil
+}
+
+func (s *MemStore) CreateAuthCode(_ context.Context, ac AuthCode) error {
+	s.mu.Lock()
+	s.codes[ac.Hash] = ac
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) ConsumeAuthCode(_ context.Context, hash string, now time.Time) (AuthCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ac, ok := s.codes[hash]
+	if !ok {
+		return AuthCode{}, ErrAuthCodeInvalid
+	}
+	if err := checkRedeemable(ac, now); err != nil {
+		return AuthCode{}, err
+	}
+	ac.UsedAt = &now
+	s.codes[hash] = ac
+	return ac, nil
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	grantClientCredentials = "client_credentials"
+	grantAuthorizationCode = "authorization_code"
+	grantRefreshToken      = "refresh_token"
+)
+
+type clientTokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// HTTP Basic first, then the form body (RFC 6749 section 2.3.1).
+func clientCredentials(r *http.Request) (id, secret string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		badRequest(w, r, "bad form", nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	switch gt := r.PostForm.Get("grant_type"); gt {
+	case grantClientCredentials:
+		s.clientCredentialsGrant(w, r)
+	case grantAuthorizationCode:
+		s.authorizationCodeGrant(w, r)
+	case grantRefreshToken:
+		s.refreshTokenGrant(w, r)
+	default:
+		badRequest(w, r, "unsupported grant_type", map[string]any{"grant_type": gt})
+	}
+}
+
+func (s *Server) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	clientID, secret := clientCredentials(r)
+	keyID, ok := apiKeyID(secret)
+	if clientID == "" || !ok {
+		unauthorized(w, r, "invalid client")
+		return
+	}
+
+	now := time.Now().UTC()
+	key, err := s.Store.GetAPIKey(r.Context(), keyID)
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			unauthorized(w, r, "invalid client")
+			return
+		}
+		s.err("token api key lookup", err)
+		serverError(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashToken(secret))) != 1 ||
+		key.AccountID != clientID || key.RevokedAt != nil {
+		unauthorized(w, r, "invalid client")
+		return
+	}
+
+	scopes := key.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, sc := range requested {
+			if !slices.Contains(key.Scopes, sc) {
+				badRequest(w, r, "invalid scope", map[string]any{"scope": sc})
+				return
+			}
+		}
+		scopes = requested
+	}
+	scope := strings.Join(scopes, " ")
+
+	tok, err := s.JWT.Issue(Claims{UserID: key.AccountID, Role: kit.RoleService, Scope: scope}, accessTokenTTL)
+	if err != nil {
+		s.err("client token issue", err)
+		serverError(w, r)
+		return
+	}
+
+	if err := s.Store.TouchAPIKey(r.Context(), key.ID, now); err != nil {
+		s.warn("api key touch", err)
+	}
+	s.audit(r, AuditTokenIssued, key.AccountID, map[string]string{
+		"grant":  grantClientCredentials,
+		"key_id": key.ID,
+		"scope":  scope,
+	})
+
+	kit.WriteJSON(w, http.StatusOK, clientTokenResp{
+		AccessToken: tok,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		Scope:       scope,
+	})
+}
+
+// Public clients only name themselves; confidential ones need their secret.
+func (s *Server) authenticateClient(w http.ResponseWriter, r *http.Request) (OAuthClient, bool) {
+	clientID, secret := clientCredentials(r)
+	if clientID == "" {
+		unauthorized(w, r, "invalid client")
+		return OAuthClient{}, false
+	}
+
+	c, err := s.Store.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			unauthorized(w, r, "invalid client")
+			return OAuthClient{}, false
+		}
+		s.err("token client lookup", err)
+		serverError(w, r)
+		return OAuthClient{}, false
+	}
+	if c.Confidential && subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(hashToken(secret))) != 1 {
+		unauthorized(w, r, "invalid client")
+		return OAuthClient{}, false
+	}
+	return c, true
+}
+
+// The session opened here describes the browser that signed in.
+func (s *Server) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	if code == "" {
+		badRequest(w, r, "code required", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	ac, err := s.Store.ConsumeAuthCode(r.Context(), hashToken(code), now)
+	if err != nil {
+		if errors.Is(err, ErrAuthCodeInvalid) {
+			badRequest(w, r, "invalid grant", nil)
+			return
+		}
+		s.err("auth code consume", err)
+		serverError(w, r)
+		return
+	}
+	if ac.ClientID != c.ID || ac.RedirectURI != r.PostForm.Get("redirect_uri") ||
+		!verifyPKCE(ac.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		badRequest(w, r, "invalid grant", nil)
+		return
+	}
+
+	u, err := s.Store.GetByID(r.Context(), ac.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			badRequest(w, r, "invalid grant", nil)
+			return
+		}
+		s.err("auth code user lookup", err)
+		serverError(w, r)
+		return
+	}
+	if u.Disabled() {
+		badRequest(w, r, "invalid grant", nil)
+		return
+	}
+
+	sess := newSession(r, u.ID, now)
+	sess.UserAgent, sess.IP = ac.UserAgent, ac.IP
+	rawRefresh, err := s.startSession(r.Context(), sess, c.ID)
+	if err != nil {
+		s.err("session start", err)
+		serverError(w, r)
+		return
+	}
+
+	tok, err := s.accessToken(u, sess.ID)
+	if err != nil {
+		s.err("token issue", err)
+		serverError(w, r)
+		return
+	}
+	idTok, err := s.idToken(u, ac, sess.ID)
+	if err != nil {
+		s.err("id token issue", err)
+		serverError(w, r)
+		return
+	}
+
+	s.audit(r, AuditTokenIssued, u.ID, map[string]string{"grant": grantAuthorizationCode, "client_id": c.ID})
+
+	kit.WriteJSON(w, http.StatusOK, tokenResp{
+		AccessToken:  tok,
+		RefreshToken: rawRefresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		IDToken:      idTok,
+		Scope:        ac.Scope,
+	})
+}
+
+func (s *Server) refreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	raw := r.PostForm.Get("refresh_token")
+	if raw == "" {
+		badRequest(w, r, "refresh_token required", nil)
+		return
+	}
+
+	u, next, rawNext, ok := s.rotateRefresh(w, r, raw, c.ID)
+	if !ok {
+		return
+	}
+
+	s.writeTokens(w, r, u, rawNext, next.FamilyID, grantRefreshToken)
+}
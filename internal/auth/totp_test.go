@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; a 6-digit code is their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(%d)=%s want=%s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCode_LowercaseSecret(t *testing.T) {
+	got, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", totpStep(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Fatalf("got=%s err=%v", got, err)
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	code := func(d int64) string {
+		c, err := totpCode(rfc6238Secret, step+d)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(0), step, true},
+		{"previous step", code(-1), step - 1, true},
+		{"next step", code(1), step + 1, true},
+		{"padded", " " + code(0) + " ", step, true},
+		{"too old", code(-2), 0, false},
+		{"too new", code(2), 0, false},
+		{"short", "12345", 0, false},
+		{"long", "1234567", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchTOTP(rfc6238Secret, tt.code, now)
+			if ok != tt.wantOK || got != tt.wantStep {
+				t.Fatalf("matchTOTP=(%d, %v) want=(%d, %v)", got, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	if _, ok := matchTOTP("not base32!", code(0), now); ok {
+		t.Fatalf("invalid secret matched")
+	}
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	defaultUserLimit = 50
+	maxUserLimit     = 200
+)
+
+var errBadUserCursor = errors.New("bad cursor")
+
+type UserQuery struct {
+	EmailPrefix   string
+	Role          string
+	Disabled      *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	After         *UserCursor
+	Limit         int
+}
+
+type UserCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"id"`
+}
+
+func (c UserCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeUserCursor(s string) (UserCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return UserCursor{}, errBadUserCursor
+	}
+
+	var c UserCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return UserCursor{}, errBadUserCursor
+	}
+	return c, nil
+}
+
+// before reports whether u is older than the cursor.
+func (c UserCursor) before(u User) bool {
+	if !u.CreatedAt.Equal(c.CreatedAt) {
+		return u.CreatedAt.Before(c.CreatedAt)
+	}
+	return u.ID < c.ID
+}
+
+func (q UserQuery) matches(u User) bool {
+	switch {
+	case q.EmailPrefix != "" && !strings.HasPrefix(u.Email, q.EmailPrefix):
+		return false
+	case q.Role != "" && u.Role != q.Role:
+		return false
+	case q.Disabled != nil && u.Disabled() != *q.Disabled:
+		return false
+	case q.CreatedAfter != nil && u.CreatedAt.Before(*q.CreatedAfter):
+		return false
+	case q.CreatedBefore != nil && !u.CreatedAt.Before(*q.CreatedBefore):
+		return false
+	case q.After != nil && !q.After.before(u):
+		return false
+	}
+	return true
+}
+
+func parseUserQuery(v url.Values) (UserQuery, error) {
+	q := UserQuery{
+		EmailPrefix: normalizeEmail(v.Get("email")),
+		Role:        v.Get("role"),
+		Limit:       defaultUserLimit,
+	}
+
+	if q.Role != "" && !kit.IsKnownRole(q.Role) {
+		return UserQuery{}, errors.New("unknown role")
+	}
+
+	if s := v.Get("disabled"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return UserQuery{}, errors.New("bad disabled")
+		}
+		q.Disabled = &b
+	}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxUserLimit {
+			return UserQuery{}, errors.New("bad limit")
+		}
+		q.Limit = n
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{{"created_after", &q.CreatedAfter}, {"created_before", &q.CreatedBefore}} {
+		s := v.Get(f.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return UserQuery{}, errors.New("bad " + f.name)
+		}
+		*f.dst = &t
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeUserCursor(s)
+		if err != nil {
+			return UserQuery{}, err
+		}
+		q.After = &c
+	}
+
+	return q, nil
+}
+
+type adminUserResp struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	DisplayName   string     `json:"display_name,omitempty"`
+	Role          string     `json:"role"`
+	EmailVerified bool       `json:"email_verified"`
+	MFAEnabled    *bool      `json:"mfa_enabled,omitempty"`
+	Disabled      bool       `json:"disabled"`
+	DisabledAt    *time.Time `json:"disabled_at,omitempty"`
+	FailedLogins  int        `json:"failed_logins"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func newAdminUserResp(u User) adminUserResp {
+	return adminUserResp{
+		ID:            u.ID,
+		Email:         u.Email,
+		DisplayName:   u.DisplayName,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		Disabled:      u.Disabled(),
+		DisabledAt:    u.DisabledAt,
+		FailedLogins:  u.FailedLogins,
+		LockedUntil:   u.LockedUntil,
+		CreatedAt:     u.CreatedAt,
+	}
+}
+
+type usersResp struct {
+	Items      []adminUserResp `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	q, err := parseUserQuery(r.URL.Query())
+	if err != nil {
+		badRequest(w, r, err.Error(), nil)
+		return
+	}
+
+	want := q.Limit
+	q.Limit++
+	users, err := s.Store.ListUsers(r.Context(), q)
+	if err != nil {
+		s.err("list users", err)
+		serverError(w, r)
+		return
+	}
+
+	resp := usersResp{Items: []adminUserResp{}}
+	if len(users) > want {
+		users = users[:want]
+		last := users[want-1]
+		resp.NextCursor = UserCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	for _, u := range users {
+		resp.Items = append(resp.Items, newAdminUserResp(u))
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := s.mfaEnabled(r.Context(), u.ID)
+	if err != nil {
+		s.err("admin user mfa lookup", err)
+		serverError(w, r)
+		return
+	}
+
+	resp := newAdminUserResp(u)
+	resp.MFAEnabled = &enabled
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	id := chi.URLParam(r, "id")
+
+	u, err := s.Store.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "user not found", map[string]any{"id": id})
+			return User{}, false
+		}
+		s.err("admin user lookup", err)
+		serverError(w, r)
+		return User{}, false
+	}
+	return u, true
+}
+
+func accountDisabled(w http.ResponseWriter, r *http.Request) {
+	kit.WriteError(w, r, http.StatusForbidden, "account disabled", nil)
+}
+
+// Verify only reports a disabled account once the password matched.
+func (s *Server) auditDisabledLogin(r *http.Request, email string) {
+	var userID string
+	if u, err := s.Store.GetByEmail(r.Context(), email); err == nil {
+		userID = u.ID
+	}
+	s.audit(r, AuditLoginFailed, userID, map[string]string{"reason": "disabled"})
+}
+
+func (s *Server) handleDisableUser(w http.ResponseWriter, r *http.Request) {
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && p.UserID == chi.URLParam(r, "id") {
+		badRequest(w, r, "cannot disable own account", nil)
+		return
+	}
+	s.setDisabled(w, r, true)
+}
+
+func (s *Server) handleEnableUser(w http.ResponseWriter, r *http.Request) {
+	s.setDisabled(w, r, false)
+}
+
+// Disabling also revokes every token the user holds.
+func (s *Server) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id := chi.URLParam(r, "id")
+
+	if err := s.Store.SetUserDisabled(r.Context(), id, disabled, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "user not found", map[string]any{"id": id})
+			return
+		}
+		s.err("set user disabled", err)
+		serverError(w, r)
+		return
+	}
+
+	typ, msg := AuditUserEnabled, "user enabled"
+	if disabled {
+		typ, msg = AuditUserDisabled, "user disabled"
+	}
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info(msg, zap.String("actor_id", p.UserID), zap.String("user_id", id))
+	}
+	s.audit(r, typ, id, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Store.ForcePasswordReset(r.Context(), u.ID, time.Now().UTC()); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "user not found", map[string]any{"id": u.ID})
+			return
+		}
+		s.err("force password reset", err)
+		serverError(w, r)
+		return
+	}
+
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && s.Log != nil {
+		s.Log.Info("password reset forced", zap.String("actor_id", p.UserID), zap.String("user_id", u.ID))
+	}
+	s.audit(r, AuditPasswordResetForced, u.ID, nil)
+	s.startPasswordReset(r.Context(), u.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	emailVerifyTTL     = 24 * time.Hour
+	purposeEmailVerify = "email_verify"
+)
+
+type verifyEmailReq struct {
+	Token string `json:"token"`
+}
+
+type resendVerificationReq struct {
+	Email string `json:"email"`
+}
+
+func (s *Server) sendVerification(ctx context.Context, u User) {
+	tok, err := s.JWT.Issue(Claims{
+		UserID:  u.ID,
+		Email:   u.Email,
+		Purpose: purposeEmailVerify,
+	}, emailVerifyTTL)
+	if err != nil {
+		s.err("verification token issue", err)
+		return
+	}
+
+	s.sendMailAsync(ctx, u.ID, Mail{
+		To:      u.Email,
+		Subject: "Confirm your MiniStore email",
+		Body:    s.verifyMailBody(tok),
+	})
+}
+
+func (s *Server) verifyMailBody(token string) string {
+	link := "verification token: " + token
+	if s.VerifyURL != "" {
+		link = s.VerifyURL + "?token=" + token
+	}
+
+	return fmt.Sprintf(
+		"Please confirm your email address.\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+		link, int(emailVerifyTTL.Hours()),
+	)
+}
+
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req verifyEmailReq
+	if err := decodeJSON(w, r, &req); err != nil || req.Token == "" {
+		badRequest(w, r, "token required", nil)
+		return
+	}
+
+	claims, err := s.JWT.ParsePurpose(req.Token, purposeEmailVerify)
+	if err != nil {
+		badRequest(w, r, "invalid or expired token", nil)
+		return
+	}
+
+	u, err := s.Store.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			badRequest(w, r, "invalid or expired token", nil)
+			return
+		}
+		s.err("verify email lookup", err)
+		serverError(w, r)
+		return
+	}
+	if u.Email != claims.Email {
+		badRequest(w, r, "invalid or expired token", nil)
+		return
+	}
+
+	if err := s.Store.MarkEmailVerified(r.Context(), u.ID, time.Now().UTC()); err != nil {
+		s.err("verify email", err)
+		serverError(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req resendVerificationReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		badRequest(w, r, "bad json", nil)
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		badRequest(w, r, "email required", nil)
+		return
+	}
+
+	u, err := s.Store.GetByEmail(r.Context(), email)
+	switch {
+	case err == nil && !u.EmailVerified:
+		s.sendVerification(r.Context(), u)
+	case err != nil && !errors.Is(err, ErrUserNotFound):
+		s.err("resend verification lookup", err)
+	}
+
+	kit.WriteJSON(w, http.StatusAccepted, map[string]any{
+		"status": "if the email is registered and unverified, a verification link has been sent",
+	})
+}
@@ -0,0 +1,194 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const maxBodyBytes = 1 << 20
+
+var (
+	errTitleRequired = errors.New("title required")
+	errPriceRequired = errors.New("price_cents required")
+	errNegativePrice = errors.New("price_cents must be >= 0")
+)
+
+type productReq struct {
+	ID         string  `json:"id"`
+	Title      *string `json:"title"`
+	PriceCents *int64  `json:"price_cents"`
+}
+
+type patchReq struct {
+	Title      *string `json:"title"`
+	PriceCents *int64  `json:"price_cents"`
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	var req productReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+
+	p, err := productFromReq(req.Title, req.PriceCents)
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	p.ID = strings.TrimSpace(req.ID)
+	if p.ID == "" {
+		p.ID = "p_" + uuid.NewString()
+	}
+	p.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	if err := s.Store.Create(r.Context(), p); err != nil {
+		if errors.Is(err, ErrProductExists) {
+			kit.WriteError(w, r, http.StatusConflict, "product already exists", map[string]any{"id": p.ID})
+			return
+		}
+		s.logError("create product failed", err, p.ID)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusCreated, p)
+}
+
+func (s *Server) replace(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req patchReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+
+	p, err := productFromReq(req.Title, req.PriceCents)
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	p.ID = id
+
+	s.update(w, r, p)
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req patchReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+
+	p, ok, err := s.Store.Get(r.Context(), id)
+	if err != nil {
+		s.logError("get product failed", err, id)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+	if !ok {
+		kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": id})
+		return
+	}
+
+	if req.Title != nil {
+		p.Title = *req.Title
+	}
+	if req.PriceCents != nil {
+		p.PriceCents = *req.PriceCents
+	}
+
+	p, err = productFromReq(&p.Title, &p.PriceCents)
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	p.ID = id
+
+	s.update(w, r, p)
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, p Product) {
+	p, err := s.Store.Update(r.Context(), p)
+	if err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": p.ID})
+			return
+		}
+		s.logError("update product failed", err, p.ID)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := s.Store.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": id})
+			return
+		}
+		s.logError("delete product failed", err, id)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func productFromReq(title *string, priceCents *int64) (Product, error) {
+	if title == nil || strings.TrimSpace(*title) == "" {
+		return Product{}, errTitleRequired
+	}
+	if priceCents == nil {
+		return Product{}, errPriceRequired
+	}
+	if *priceCents < 0 {
+		return Product{}, errNegativePrice
+	}
+
+	return Product{
+		Title:      strings.TrimSpace(*title),
+		PriceCents: *priceCents,
+		InStock:    true,
+	}, nil
+}
+
+func (s *Server) logError(msg string, err error, id string) {
+	if s.Log != nil {
+		s.Log.Error(msg, zap.Error(err), zap.String("id", id))
+	}
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer func() { _ = r.Body.Close() }()
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("extra data after json object")
+	}
+	return nil
+}
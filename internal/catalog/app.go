@@ -24,6 +24,14 @@ type HTTPDeps struct {
 func NewHandler(s *Server, deps HTTPDeps) http.Handler {
 	r := chi.NewRouter()
 
+	if deps.Registry != nil && s.Metrics == nil {
+		threshold := s.LowStockThreshold
+		if threshold <= 0 {
+			threshold = DefaultLowStockThreshold
+		}
+		s.Metrics = NewStockMetrics(deps.Registry, s.Store, threshold)
+	}
+
 	setupMiddleware(r, deps)
 	setupMetrics(r, deps)
 
@@ -0,0 +1,46 @@
+package catalog
+
+import (
+	"net/http"
+	"strings"
+
+	"MiniStore/internal/auth"
+	"MiniStore/pkg/kit"
+)
+
+const bearerPrefix = "Bearer "
+
+func AuthJWT(jwt *auth.TokenMaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, ok := bearerToken(r)
+			if !ok {
+				kit.WriteError(w, r, http.StatusUnauthorized, "missing token", nil)
+				return
+			}
+
+			claims, err := jwt.Parse(tok)
+			if err != nil {
+				kit.WriteError(w, r, http.StatusUnauthorized, "invalid token", nil)
+				return
+			}
+
+			ctx := kit.WithPrincipal(r.Context(), claims.Principal())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	authz := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authz, bearerPrefix) {
+		return "", false
+	}
+
+	tok := strings.TrimSpace(strings.TrimPrefix(authz, bearerPrefix))
+	if tok == "" {
+		return "", false
+	}
+
+	return tok, true
+}
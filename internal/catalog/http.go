@@ -3,20 +3,30 @@ package catalog
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
+	"MiniStore/internal/auth"
 	"MiniStore/pkg/kit"
 )
 
 type Server struct {
-	Store Store
-	Log   *zap.Logger
+	Store   Store
+	Log     *zap.Logger
+	JWT     *auth.TokenMaker
+	Metrics *StockMetrics
+
+	// LowStockThreshold feeds the low-stock gauge; zero means the default.
+	LowStockThreshold int
 }
 
-const readyTimeout = 1 * time.Second
+const (
+	readyTimeout = 1 * time.Second
+	maxBatchIDs  = 200
+)
 
 func (s *Server) Routes() http.Handler {
 	r := chi.NewRouter()
@@ -26,6 +36,21 @@ func (s *Server) Routes() http.Handler {
 
 	r.Get("/products", s.list)
 	r.Get("/products/{id}", s.get)
+	r.Post("/products:batchGet", s.batchGet)
+
+	if s.JWT != nil {
+		r.With(AuthJWT(s.JWT), kit.RequirePermission(kit.PermStockOrders)).Post("/internal/stock/orders", s.orderStock)
+
+		r.Group(func(ar chi.Router) {
+			ar.Use(AuthJWT(s.JWT), kit.RequirePermission(kit.PermProductsWrite))
+			ar.Post("/products", s.create)
+			ar.Put("/products/{id}", s.replace)
+			ar.Patch("/products/{id}", s.patch)
+			ar.Delete("/products/{id}", s.delete)
+			ar.Get("/products/{id}/stock/movements", s.listMovements)
+			ar.Post("/products/{id}/stock/movements", s.recordMovement)
+		})
+	}
 
 	return r
 }
@@ -50,7 +75,16 @@ func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) list(w http.ResponseWriter, r *http.Request) {
-	products, err := s.Store.ListSortedByID(r.Context())
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	limit := q.Limit
+	q.Limit = limit + 1
+
+	products, err := s.Store.List(r.Context(), q)
 	if err != nil {
 		if s.Log != nil {
 			s.Log.Error("list products failed", zap.Error(err))
@@ -59,7 +93,85 @@ func (s *Server) list(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kit.WriteJSON(w, http.StatusOK, products)
+	resp := listResp{Items: products}
+	if len(products) > limit {
+		resp.Items = products[:limit]
+		resp.NextCursor = cursorAfter(products[limit-1], q.Sort, q.Desc).Encode()
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+type batchGetReq struct {
+	IDs []string `json:"ids"`
+}
+
+type batchGetResp struct {
+	Items   []Product `json:"items"`
+	Missing []string  `json:"missing"`
+}
+
+func (s *Server) batchGet(w http.ResponseWriter, r *http.Request) {
+	var req batchGetReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+
+	ids := dedupIDs(req.IDs)
+	if len(ids) == 0 {
+		kit.WriteError(w, r, http.StatusBadRequest, "ids required", nil)
+		return
+	}
+	if len(ids) > maxBatchIDs {
+		kit.WriteError(w, r, http.StatusBadRequest, "too many ids", map[string]any{"max": maxBatchIDs})
+		return
+	}
+
+	products, err := s.Store.GetMany(r.Context(), ids)
+	if err != nil {
+		if s.Log != nil {
+			s.Log.Error("batch get products failed", zap.Error(err), zap.Int("count", len(ids)))
+		}
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	found := make(map[string]Product, len(products))
+	for _, p := range products {
+		found[p.ID] = p
+	}
+
+	resp := batchGetResp{
+		Items:   make([]Product, 0, len(products)),
+		Missing: make([]string, 0),
+	}
+	for _, id := range ids {
+		if p, ok := found[id]; ok {
+			resp.Items = append(resp.Items, p)
+		} else {
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+func dedupIDs(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
 }
 
 func (s *Server) get(w http.ResponseWriter, r *http.Request) {
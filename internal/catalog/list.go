@@ -0,0 +1,156 @@
+package catalog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var (
+	ErrBadCursor = errors.New("bad cursor")
+
+	errBadLimit  = errors.New("bad limit")
+	errBadSort   = errors.New("bad sort")
+	errBadPrice  = errors.New("bad price filter")
+	errBadRange  = errors.New("min_price > max_price")
+	errSortDrift = errors.New("cursor does not match sort")
+)
+
+type listResp struct {
+	Items      []Product `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+func parseListQuery(v url.Values) (ListQuery, error) {
+	q := ListQuery{Limit: defaultListLimit, Sort: SortByID}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return ListQuery{}, errBadLimit
+		}
+		q.Limit = n
+	}
+
+	if s := v.Get("sort"); s != "" {
+		if strings.HasPrefix(s, "-") {
+			q.Desc = true
+			s = s[1:]
+		}
+		switch SortField(s) {
+		case SortByID, SortByPrice, SortByCreatedAt:
+			q.Sort = SortField(s)
+		default:
+			return ListQuery{}, errBadSort
+		}
+	}
+
+	var err error
+	if q.MinPrice, err = parsePrice(v.Get("min_price")); err != nil {
+		return ListQuery{}, err
+	}
+	if q.MaxPrice, err = parsePrice(v.Get("max_price")); err != nil {
+		return ListQuery{}, err
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return ListQuery{}, errBadRange
+	}
+
+	q.TitlePrefix = strings.TrimSpace(v.Get("title_prefix"))
+
+	if s := v.Get("cursor"); s != "" {
+		c, err := DecodeCursor(s)
+		if err != nil {
+			return ListQuery{}, err
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return ListQuery{}, errSortDrift
+		}
+		q.After = &c
+	}
+
+	return q, nil
+}
+
+func parsePrice(s string) (*int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return nil, errBadPrice
+	}
+	return &n, nil
+}
+
+type Cursor struct {
+	Sort       SortField `json:"s"`
+	Desc       bool      `json:"d,omitempty"`
+	ID         string    `json:"id"`
+	PriceCents int64     `json:"p,omitempty"`
+	CreatedAt  time.Time `json:"c,omitempty"`
+}
+
+func cursorAfter(p Product, sort SortField, desc bool) Cursor {
+	return Cursor{
+		Sort:       sort,
+		Desc:       desc,
+		ID:         p.ID,
+		PriceCents: p.PriceCents,
+		CreatedAt:  p.CreatedAt,
+	}
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return Cursor{}, ErrBadCursor
+	}
+	return c, nil
+}
+
+func less(a, b Cursor, sort SortField) bool {
+	switch sort {
+	case SortByPrice:
+		if a.PriceCents != b.PriceCents {
+			return a.PriceCents < b.PriceCents
+		}
+	case SortByCreatedAt:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	}
+	return a.ID < b.ID
+}
+
+func matchesFilter(p Product, q ListQuery) bool {
+	if q.MinPrice != nil && p.PriceCents < *q.MinPrice {
+		return false
+	}
+	if q.MaxPrice != nil && p.PriceCents > *q.MaxPrice {
+		return false
+	}
+	if q.TitlePrefix != "" && !strings.HasPrefix(strings.ToLower(p.Title), strings.ToLower(q.TitlePrefix)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,391 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	DefaultLowStockThreshold = 5
+
+	defaultMovementLimit = 50
+	maxMovementLimit     = 200
+	maxReferenceLen      = 200
+	maxNoteLen           = 500
+	metricsTimeout       = 2 * time.Second
+	stockSummaryMaxAge   = 1 * time.Minute
+)
+
+var (
+	errUnknownKind    = errors.New("unknown kind")
+	errBadDelta       = errors.New("bad delta for kind")
+	errReferenceLong  = errors.New("reference too long")
+	errNoteLong       = errors.New("note too long")
+	errRefRequired    = errors.New("reference required")
+	errLinesRequired  = errors.New("lines required")
+	errTooManyLines   = errors.New("too many lines")
+	errBadStockLine   = errors.New("bad line")
+	errDuplicateLine  = errors.New("duplicate product_id")
+	errOrderMoveKinds = errors.New("kind must be sale or return")
+)
+
+// Adjustments correct stocktake differences and may go either way.
+func checkDelta(kind string, delta int) error {
+	switch kind {
+	case MovementReceipt, MovementReturn:
+		if delta <= 0 {
+			return errBadDelta
+		}
+	case MovementSale:
+		if delta >= 0 {
+			return errBadDelta
+		}
+	case MovementAdjustment:
+		if delta == 0 {
+			return errBadDelta
+		}
+	default:
+		return errUnknownKind
+	}
+	return nil
+}
+
+type movementReq struct {
+	Kind      string `json:"kind"`
+	Delta     int    `json:"delta"`
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+}
+
+type movementResp struct {
+	Product  Product   `json:"product"`
+	Movement *Movement `json:"movement"`
+}
+
+type movementsResp struct {
+	Items      []Movement `json:"items"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+func (s *Server) recordMovement(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req movementReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+	req.Reference = strings.TrimSpace(req.Reference)
+	req.Note = strings.TrimSpace(req.Note)
+
+	if err := checkMovementReq(req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	m := Movement{
+		ProductID: id,
+		Kind:      req.Kind,
+		Delta:     req.Delta,
+		Reference: req.Reference,
+		Note:      req.Note,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok {
+		m.ActorID = p.UserID
+	}
+
+	recorded, ok := s.applyMovements(w, r, []Movement{m})
+	if !ok {
+		return
+	}
+
+	p, found, err := s.Store.Get(r.Context(), id)
+	if err != nil || !found {
+		s.logError("get product after stock movement failed", err, id)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	// A repeated reference is not recorded again; report the current stock.
+	if len(recorded) == 0 {
+		kit.WriteJSON(w, http.StatusOK, movementResp{Product: p})
+		return
+	}
+
+	if s.Log != nil {
+		s.Log.Info("stock movement recorded",
+			zap.String("actor_id", m.ActorID),
+			zap.String("id", id),
+			zap.String("kind", m.Kind),
+			zap.Int("delta", m.Delta))
+	}
+	kit.WriteJSON(w, http.StatusCreated, movementResp{Product: p, Movement: &recorded[0]})
+}
+
+func checkMovementReq(req movementReq) error {
+	if err := checkDelta(req.Kind, req.Delta); err != nil {
+		return err
+	}
+	if len(req.Reference) > maxReferenceLen {
+		return errReferenceLong
+	}
+	if len(req.Note) > maxNoteLen {
+		return errNoteLong
+	}
+	return nil
+}
+
+func (s *Server) listMovements(w http.ResponseWriter, r *http.Request) {
+	q := MovementQuery{ProductID: chi.URLParam(r, "id"), Limit: defaultMovementLimit}
+
+	v := r.URL.Query()
+	if raw := v.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxMovementLimit {
+			kit.WriteError(w, r, http.StatusBadRequest, "bad limit", nil)
+			return
+		}
+		q.Limit = n
+	}
+	if raw := v.Get("cursor"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			kit.WriteError(w, r, http.StatusBadRequest, "bad cursor", nil)
+			return
+		}
+		q.BeforeID = n
+	}
+
+	limit := q.Limit
+	q.Limit = limit + 1
+
+	items, err := s.Store.ListMovements(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": q.ProductID})
+			return
+		}
+		s.logError("list stock movements failed", err, q.ProductID)
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	resp := movementsResp{Items: items}
+	if resp.Items == nil {
+		resp.Items = []Movement{}
+	}
+	if len(items) > limit {
+		resp.Items = items[:limit]
+		resp.NextCursor = strconv.FormatInt(items[limit-1].ID, 10)
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+type stockLine struct {
+	ProductID string `json:"product_id"`
+	Qty       int    `json:"qty"`
+}
+
+type orderStockReq struct {
+	Kind      string      `json:"kind"`
+	Reference string      `json:"reference"`
+	Lines     []stockLine `json:"lines"`
+}
+
+// The order ID is the reference, so a retried call does not move stock twice.
+func (s *Server) orderStock(w http.ResponseWriter, r *http.Request) {
+	var req orderStockReq
+	if err := decodeJSON(w, r, &req); err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+		return
+	}
+
+	ms, err := orderMovements(req, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	recorded, ok := s.applyMovements(w, r, ms)
+	if !ok {
+		return
+	}
+	if recorded == nil {
+		recorded = []Movement{}
+	}
+
+	kit.WriteJSON(w, http.StatusOK, movementsResp{Items: recorded})
+}
+
+func orderMovements(req orderStockReq, now time.Time) ([]Movement, error) {
+	ref := strings.TrimSpace(req.Reference)
+	switch {
+	case req.Kind != MovementSale && req.Kind != MovementReturn:
+		return nil, errOrderMoveKinds
+	case ref == "":
+		return nil, errRefRequired
+	case len(ref) > maxReferenceLen:
+		return nil, errReferenceLong
+	case len(req.Lines) == 0:
+		return nil, errLinesRequired
+	case len(req.Lines) > maxBatchIDs:
+		return nil, errTooManyLines
+	}
+
+	seen := make(map[string]struct{}, len(req.Lines))
+	ms := make([]Movement, 0, len(req.Lines))
+	for _, l := range req.Lines {
+		pid := strings.TrimSpace(l.ProductID)
+		if pid == "" || l.Qty <= 0 {
+			return nil, errBadStockLine
+		}
+		if _, dup := seen[pid]; dup {
+			return nil, errDuplicateLine
+		}
+		seen[pid] = struct{}{}
+
+		delta := l.Qty
+		if req.Kind == MovementSale {
+			delta = -l.Qty
+		}
+		ms = append(ms, Movement{
+			ProductID: pid,
+			Kind:      req.Kind,
+			Delta:     delta,
+			Reference: ref,
+			CreatedAt: now,
+		})
+	}
+	return ms, nil
+}
+
+func (s *Server) applyMovements(w http.ResponseWriter, r *http.Request, ms []Movement) ([]Movement, bool) {
+	recorded, err := s.Store.RecordMovements(r.Context(), ms)
+	if err != nil {
+		var ise *InsufficientStockError
+		switch {
+		case errors.As(err, &ise):
+			kit.WriteError(w, r, http.StatusConflict, "insufficient stock", map[string]any{"product_ids": ise.ProductIDs})
+		case errors.Is(err, ErrProductNotFound):
+			kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": ms[0].ProductID})
+		default:
+			s.logError("record stock movements failed", err, ms[0].ProductID)
+			kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		}
+		return nil, false
+	}
+
+	s.Metrics.movements(recorded)
+	return recorded, true
+}
+
+type StockMetrics struct {
+	Movements *prometheus.CounterVec
+
+	levels *stockCollector
+}
+
+// The gauges are re-read after stock moves, or after stockSummaryMaxAge for
+// changes made by product edits.
+func NewStockMetrics(reg *prometheus.Registry, store Store, lowThreshold int) *StockMetrics {
+	m := &StockMetrics{
+		Movements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catalog_stock_movements_total",
+			Help: "Stock movements recorded, by kind",
+		}, []string{"kind"}),
+		levels: &stockCollector{
+			store:     store,
+			threshold: lowThreshold,
+			low: prometheus.NewDesc("catalog_products_low_stock",
+				"Tracked products at or below the low-stock threshold", nil, nil),
+			out: prometheus.NewDesc("catalog_products_out_of_stock",
+				"Tracked products with no stock left", nil, nil),
+		},
+	}
+
+	reg.MustRegister(m.Movements, m.levels)
+	return m
+}
+
+func (m *StockMetrics) movements(ms []Movement) {
+	if m == nil || len(ms) == 0 {
+		return
+	}
+	for _, mv := range ms {
+		m.Movements.WithLabelValues(mv.Kind).Inc()
+	}
+	m.levels.invalidate()
+}
+
+type stockCollector struct {
+	store     Store
+	threshold int
+	low, out  *prometheus.Desc
+
+	mu      sync.Mutex
+	gen     uint64 // bumped on every recorded movement
+	sumGen  uint64 // gen the cached summary was read at
+	sum     StockSummary
+	sumTime time.Time
+}
+
+func (c *stockCollector) invalidate() {
+	c.mu.Lock()
+	c.gen++
+	c.mu.Unlock()
+}
+
+func (c *stockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.low
+	ch <- c.out
+}
+
+func (c *stockCollector) Collect(ch chan<- prometheus.Metric) {
+	sum, err := c.summary(time.Now())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.low, err)
+		ch <- prometheus.NewInvalidMetric(c.out, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.low, prometheus.GaugeValue, float64(sum.Low))
+	ch <- prometheus.MustNewConstMetric(c.out, prometheus.GaugeValue, float64(sum.OutOfStock))
+}
+
+// The store is queried without holding the lock.
+func (c *stockCollector) summary(now time.Time) (StockSummary, error) {
+	c.mu.Lock()
+	gen, sum := c.gen, c.sum
+	fresh := !c.sumTime.IsZero() && c.sumGen == gen && now.Sub(c.sumTime) < stockSummaryMaxAge
+	c.mu.Unlock()
+	if fresh {
+		return sum, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+
+	sum, err := c.store.StockSummary(ctx, c.threshold)
+	if err != nil {
+		return StockSummary{}, err
+	}
+
+	c.mu.Lock()
+	if c.sumTime.IsZero() || !now.Before(c.sumTime) {
+		c.sum, c.sumGen, c.sumTime = sum, gen, now
+	}
+	c.mu.Unlock()
+	return sum, nil
+}
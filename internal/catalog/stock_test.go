@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCheckDelta(t *testing.T) {
+	tests := []struct {
+		kind    string
+		delta   int
+		wantErr error
+	}{
+		{MovementReceipt, 3, nil},
+		{MovementReceipt, 0, errBadDelta},
+		{MovementReceipt, -1, errBadDelta},
+		{MovementReturn, 1, nil},
+		{MovementReturn, -1, errBadDelta},
+		{MovementSale, -2, nil},
+		{MovementSale, 0, errBadDelta},
+		{MovementSale, 2, errBadDelta},
+		{MovementAdjustment, -4, nil},
+		{MovementAdjustment, 4, nil},
+		{MovementAdjustment, 0, errBadDelta},
+		{"restock", 1, errUnknownKind},
+		{"", 1, errUnknownKind},
+	}
+	for _, tt := range tests {
+		if err := checkDelta(tt.kind, tt.delta); !errors.Is(err, tt.wantErr) {
+			t.Errorf("checkDelta(%q, %d)=%v want=%v", tt.kind, tt.delta, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOrderMovements(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	line := func(pid string, qty int) stockLine { return stockLine{ProductID: pid, Qty: qty} }
+	tooMany := make([]stockLine, maxBatchIDs+1)
+	for i := range tooMany {
+		tooMany[i] = line("p"+strings.Repeat("x", i), 1)
+	}
+
+	tests := []struct {
+		name       string
+		req        orderStockReq
+		wantErr    error
+		wantDeltas []int
+	}{
+		{"sale", orderStockReq{Kind: MovementSale, Reference: " o1 ", Lines: []stockLine{line("p1", 2), line(" p2 ", 1)}}, nil, []int{-2, -1}},
+		{"return", orderStockReq{Kind: MovementReturn, Reference: "o1", Lines: []stockLine{line("p1", 2)}}, nil, []int{2}},
+		{"receipt not allowed", orderStockReq{Kind: MovementReceipt, Reference: "o1", Lines: []stockLine{line("p1", 1)}}, errOrderMoveKinds, nil},
+		{"blank reference", orderStockReq{Kind: MovementSale, Reference: "  ", Lines: []stockLine{line("p1", 1)}}, errRefRequired, nil},
+		{"long reference", orderStockReq{Kind: MovementSale, Reference: strings.Repeat("r", maxReferenceLen+1), Lines: []stockLine{line("p1", 1)}}, errReferenceLong, nil},
+		{"no lines", orderStockReq{Kind: MovementSale, Reference: "o1"}, errLinesRequired, nil},
+		{"too many lines", orderStockReq{Kind: MovementSale, Reference: "o1", Lines: tooMany}, errTooManyLines, nil},
+		{"zero qty", orderStockReq{Kind: MovementSale, Reference: "o1", Lines: []stockLine{line("p1", 0)}}, errBadStockLine, nil},
+		{"blank product", orderStockReq{Kind: MovementSale, Reference: "o1", Lines: []stockLine{line(" ", 1)}}, errBadStockLine, nil},
+		{"duplicate product", orderStockReq{Kind: MovementSale, Reference: "o1", Lines: []stockLine{line("p1", 1), line("p1 ", 2)}}, errDuplicateLine, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := orderMovements(tt.req, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err=%v want=%v", err, tt.wantErr)
+			}
+			if len(ms) != len(tt.wantDeltas) {
+				t.Fatalf("movements=%+v want deltas=%v", ms, tt.wantDeltas)
+			}
+			for i, m := range ms {
+				if m.Delta != tt.wantDeltas[i] || m.Kind != tt.req.Kind || m.Reference != "o1" ||
+					m.ProductID != strings.TrimSpace(tt.req.Lines[i].ProductID) || !m.CreatedAt.Equal(now) {
+					t.Fatalf("movement[%d]=%+v", i, m)
+				}
+			}
+		})
+	}
+}
+
+type countingStore struct {
+	Store
+	calls int
+}
+
+func (s *countingStore) StockSummary(context.Context, int) (StockSummary, error) {
+	s.calls++
+	return StockSummary{Low: s.calls}, nil
+}
+
+func TestStockMetricsCachesSummary(t *testing.T) {
+	store := &countingStore{}
+	m := NewStockMetrics(prometheus.NewRegistry(), store, DefaultLowStockThreshold)
+	c := m.levels
+	now := time.Now()
+
+	steps := []struct {
+		name      string
+		at        time.Time
+		moved     []Movement
+		wantLow   int
+		wantCalls int
+	}{
+		{"first scrape reads the store", now, nil, 1, 1},
+		{"unchanged stock is cached", now.Add(time.Second), nil, 1, 1},
+		{"nothing recorded keeps the cache", now.Add(2 * time.Second), []Movement{}, 1, 1},
+		{"a movement refreshes", now.Add(3 * time.Second), []Movement{{Kind: MovementSale}}, 2, 2},
+		{"old summary refreshes", now.Add(3*time.Second + stockSummaryMaxAge), nil, 3, 3},
+	}
+	for _, st := range steps {
+		m.movements(st.moved)
+		sum, err := c.summary(st.at)
+		if err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if sum.Low != st.wantLow || store.calls != st.wantCalls {
+			t.Fatalf("%s: low=%d calls=%d want low=%d calls=%d", st.name, sum.Low, store.calls, st.wantLow, st.wantCalls)
+		}
+	}
+}
@@ -1,15 +1,107 @@
 package catalog
 
-import "context"
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
 
+var (
+	ErrProductExists   = errors.New("product already exists")
+	ErrProductNotFound = errors.New("product not found")
+)
+
+// Product.Available is nil while stock is not tracked; such products are always
+// in stock.
 type Product struct {
-	ID         string `json:"id"`
-	Title      string `json:"title"`
-	PriceCents int64  `json:"price_cents"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	PriceCents int64     `json:"price_cents"`
+	Available  *int      `json:"available"`
+	InStock    bool      `json:"in_stock"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func inStock(available *int) bool {
+	return available == nil || *available > 0
+}
+
+func tracksUntracked(kind string) bool {
+	return kind == MovementReceipt || kind == MovementAdjustment
+}
+
+const (
+	MovementReceipt    = "receipt"
+	MovementAdjustment = "adjustment"
+	MovementSale       = "sale"
+	MovementReturn     = "return"
+)
+
+// A movement with a reference is recorded at most once per kind and product.
+type Movement struct {
+	ID         int64     `json:"id"`
+	ProductID  string    `json:"product_id"`
+	Kind       string    `json:"kind"`
+	Delta      int       `json:"delta"`
+	StockAfter int       `json:"stock_after"`
+	Reference  string    `json:"reference,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type InsufficientStockError struct {
+	ProductIDs []string
+}
+
+func (e *InsufficientStockError) Error() string {
+	return "insufficient stock: " + strings.Join(e.ProductIDs, ",")
+}
+
+type MovementQuery struct {
+	ProductID string
+	BeforeID  int64
+	Limit     int
+}
+
+type StockSummary struct {
+	Low        int
+	OutOfStock int
+}
+
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByPrice     SortField = "price"
+	SortByCreatedAt SortField = "created_at"
+)
+
+type ListQuery struct {
+	Limit int
+	Sort  SortField
+	Desc  bool
+	After *Cursor
+
+	MinPrice    *int64
+	MaxPrice    *int64
+	TitlePrefix string
 }
 
 type Store interface {
-	ListSortedByID(ctx context.Context) ([]Product, error)
+	List(ctx context.Context, q ListQuery) ([]Product, error)
 	Get(ctx context.Context, id string) (Product, bool, error)
+	GetMany(ctx context.Context, ids []string) ([]Product, error)
+	Create(ctx context.Context, p Product) error
+	Update(ctx context.Context, p Product) (Product, error)
+	Delete(ctx context.Context, id string) error
+
+	// RecordMovements applies all movements or none, skipping ones already
+	// in the ledger.
+	RecordMovements(ctx context.Context, ms []Movement) ([]Movement, error)
+	ListMovements(ctx context.Context, q MovementQuery) ([]Movement, error)
+	StockSummary(ctx context.Context, lowThreshold int) (StockSummary, error)
+
 	Ping(ctx context.Context) error
 }
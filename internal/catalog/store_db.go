@@ -3,14 +3,22 @@ package catalog
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 const (
 	pingTimeout  = 1 * time.Second
 	queryTimeout = 3 * time.Second
+	pgUniqueCode = "23505"
 )
 
+const productColumns = "id, title, price_cents, stock, created_at"
+
 type PostgresStore struct {
 	db *sql.DB
 }
@@ -25,24 +33,21 @@ func (s *PostgresStore) Ping(ctx context.Context) error {
 	})
 }
 
-func (s *PostgresStore) ListSortedByID(ctx context.Context) ([]Product, error) {
-	var out []Product
+func (s *PostgresStore) List(ctx context.Context, q ListQuery) ([]Product, error) {
+	query, args := buildListQuery(q)
 
+	var out []Product
 	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
-		rows, err := s.db.QueryContext(ctx, `
-			SELECT id, title, price_cents
-			FROM products
-			ORDER BY id ASC
-		`)
+		rows, err := s.db.QueryContext(ctx, query, args...)
 		if err != nil {
 			return err
 		}
 		defer rows.Close()
 
-		out = make([]Product, 0, 16)
+		out = make([]Product, 0, q.Limit)
 		for rows.Next() {
-			var p Product
-			if err := rows.Scan(&p.ID, &p.Title, &p.PriceCents); err != nil {
+			p, err := scanProduct(rows)
+			if err != nil {
 				return err
 			}
 			out = append(out, p)
@@ -56,6 +61,77 @@ func (s *PostgresStore) ListSortedByID(ctx context.Context) ([]Product, error) {
 	return out, nil
 }
 
+func buildListQuery(q ListQuery) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.MinPrice != nil {
+		where = append(where, "price_cents >= "+arg(*q.MinPrice))
+	}
+	if q.MaxPrice != nil {
+		where = append(where, "price_cents <= "+arg(*q.MaxPrice))
+	}
+	if q.TitlePrefix != "" {
+		where = append(where, "lower(title) LIKE "+arg(escapeLike(strings.ToLower(q.TitlePrefix))+"%")+` ESCAPE '\'`)
+	}
+
+	col := sortColumn(q.Sort)
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if q.After != nil {
+		switch q.Sort {
+		case SortByPrice:
+			where = append(where, "(price_cents, id) "+cmp+" ("+arg(q.After.PriceCents)+", "+arg(q.After.ID)+")")
+		case SortByCreatedAt:
+			where = append(where, "(created_at, id) "+cmp+" ("+arg(q.After.CreatedAt)+", "+arg(q.After.ID)+")")
+		default:
+			where = append(where, "id "+cmp+" "+arg(q.After.ID))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT " + productColumns + " FROM products")
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	if col == "id" {
+		b.WriteString(" ORDER BY id " + dir)
+	} else {
+		b.WriteString(" ORDER BY " + col + " " + dir + ", id " + dir)
+	}
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(q.Limit))
+	}
+
+	return b.String(), args
+}
+
+func sortColumn(f SortField) string {
+	switch f {
+	case SortByPrice:
+		return "price_cents"
+	case SortByCreatedAt:
+		return "created_at"
+	default:
+		return "id"
+	}
+}
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func (s *PostgresStore) Get(ctx context.Context, id string) (Product, bool, error) {
 	var (
 		p   Product
@@ -63,11 +139,12 @@ func (s *PostgresStore) Get(ctx context.Context, id string) (Product, bool, erro
 	)
 
 	err = withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
-		return s.db.QueryRowContext(ctx, `
-			SELECT id, title, price_cents
+		p, err = scanProduct(s.db.QueryRowContext(ctx, `
+			SELECT `+productColumns+`
 			FROM products
 			WHERE id = $1
-		`, id).Scan(&p.ID, &p.Title, &p.PriceCents)
+		`, id))
+		return err
 	})
 
 	if err == sql.ErrNoRows {
@@ -79,8 +156,308 @@ func (s *PostgresStore) Get(ctx context.Context, id string) (Product, bool, erro
 	return p, true, nil
 }
 
+func (s *PostgresStore) GetMany(ctx context.Context, ids []string) ([]Product, error) {
+	var out []Product
+
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+productColumns+`
+			FROM products
+			WHERE id = ANY($1)
+		`, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		out = make([]Product, 0, len(ids))
+		for rows.Next() {
+			p, err := scanProduct(rows)
+			if err != nil {
+				return err
+			}
+			out = append(out, p)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, p Product) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO products (id, title, price_cents, created_at)
+			VALUES ($1, $2, $3, $4)
+		`, p.ID, p.Title, p.PriceCents, p.CreatedAt)
+
+		if err == nil {
+			return nil
+		}
+		if isUniqueViolation(err) {
+			return ErrProductExists
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) Update(ctx context.Context, p Product) (Product, error) {
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			UPDATE products
+			SET title = $2, price_cents = $3
+			WHERE id = $1
+			RETURNING stock, created_at
+		`, p.ID, p.Title, p.PriceCents).Scan(&p.Available, &p.CreatedAt)
+	})
+
+	if err == sql.ErrNoRows {
+		return Product{}, ErrProductNotFound
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	p.InStock = inStock(p.Available)
+	return p, nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id string) error {
+	return withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM products
+			WHERE id = $1
+		`, id)
+		if err != nil {
+			return err
+		}
+		return requireAffected(res)
+	})
+}
+
+func (s *PostgresStore) RecordMovements(ctx context.Context, ms []Movement) ([]Movement, error) {
+	ids := make([]string, 0, len(ms))
+	for _, m := range ms {
+		ids = append(ids, m.ProductID)
+	}
+
+	var out []Movement
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		// Lock rows in id order so concurrent orders cannot deadlock.
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, stock
+			FROM products
+			WHERE id = ANY($1)
+			ORDER BY id
+			FOR UPDATE
+		`, ids)
+		if err != nil {
+			return err
+		}
+		stock := make(map[string]*int, len(ids))
+		for rows.Next() {
+			var (
+				id string
+				n  *int
+			)
+			if err := rows.Scan(&id, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			stock[id] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var (
+			apply []Movement
+			short []string
+		)
+		for _, m := range ms {
+			cur, ok := stock[m.ProductID]
+			if !ok {
+				return ErrProductNotFound
+			}
+			if cur == nil && !tracksUntracked(m.Kind) {
+				continue
+			}
+			next := m.Delta
+			if cur != nil {
+				next += *cur
+			}
+			m.StockAfter = next
+
+			// A short line is never inserted (stock_after >= 0); it is only
+			// reported if it is not a replay of a recorded movement.
+			if next < 0 {
+				var dup bool
+				if err := tx.QueryRowContext(ctx, `
+					SELECT EXISTS (
+						SELECT 1 FROM stock_movements
+						WHERE kind = $1 AND reference = $2 AND product_id = $3 AND reference <> ''
+					)
+				`, m.Kind, m.Reference, m.ProductID).Scan(&dup); err != nil {
+					return err
+				}
+				if !dup {
+					short = append(short, m.ProductID)
+				}
+				continue
+			}
+
+			// The unique index on (kind, reference, product_id) skips replays.
+			err := tx.QueryRowContext(ctx, `
+				INSERT INTO stock_movements (product_id, kind, delta, stock_after, reference, note, actor_id, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				ON CONFLICT (kind, reference, product_id) WHERE reference <> '' DO NOTHING
+				RETURNING id
+			`, m.ProductID, m.Kind, m.Delta, m.StockAfter, m.Reference, m.Note, m.ActorID, m.CreatedAt).Scan(&m.ID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			stock[m.ProductID] = &next
+			apply = append(apply, m)
+		}
+		if len(short) > 0 {
+			return &InsufficientStockError{ProductIDs: short}
+		}
+
+		for _, m := range apply {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products SET stock = $2 WHERE id = $1
+			`, m.ProductID, m.StockAfter); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		out = apply
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) ListMovements(ctx context.Context, q MovementQuery) ([]Movement, error) {
+	var out []Movement
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)
+		`, q.ProductID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrProductNotFound
+		}
+
+		query := `
+			SELECT id, product_id, kind, delta, stock_after, reference, note, actor_id, created_at
+			FROM stock_movements
+			WHERE product_id = $1`
+		args := []any{q.ProductID}
+		if q.BeforeID > 0 {
+			args = append(args, q.BeforeID)
+			query += " AND id < $" + strconv.Itoa(len(args))
+		}
+		query += " ORDER BY id DESC"
+		if q.Limit > 0 {
+			args = append(args, q.Limit)
+			query += " LIMIT $" + strconv.Itoa(len(args))
+		}
+
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m Movement
+			if err := rows.Scan(&m.ID, &m.ProductID, &m.Kind, &m.Delta, &m.StockAfter,
+				&m.Reference, &m.Note, &m.ActorID, &m.CreatedAt); err != nil {
+				return err
+			}
+			out = append(out, m)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) StockSummary(ctx context.Context, lowThreshold int) (StockSummary, error) {
+	var sum StockSummary
+	err := withTimeout(ctx, queryTimeout, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, `
+			SELECT
+				COUNT(*) FILTER (WHERE stock <= $1),
+				COUNT(*) FILTER (WHERE stock = 0)
+			FROM products
+			WHERE stock IS NOT NULL
+		`, lowThreshold).Scan(&sum.Low, &sum.OutOfStock)
+	})
+	return sum, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProduct(row rowScanner) (Product, error) {
+	var p Product
+	if err := row.Scan(&p.ID, &p.Title, &p.PriceCents, &p.Available, &p.CreatedAt); err != nil {
+		return Product{}, err
+	}
+	p.InStock = inStock(p.Available)
+	return p, nil
+}
+
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
 func withTimeout(parent context.Context, d time.Duration, fn func(ctx context.Context) error) error {
 	ctx, cancel := context.WithTimeout(parent, d)
 	defer cancel()
 	return fn(ctx)
 }
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueCode
+}
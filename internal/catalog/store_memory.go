@@ -4,18 +4,23 @@ import (
 	"context"
 	"sort"
 	"sync"
+	"time"
 )
 
 type MemStore struct {
-	mu       sync.RWMutex
-	products map[string]Product
+	mu             sync.RWMutex
+	products       map[string]Product
+	movements      []Movement
+	lastMovementID int64
 }
 
 func NewMemStore() *MemStore {
+	now := time.Now().UTC()
+
 	return &MemStore{
 		products: map[string]Product{
-			"p1": {ID: "p1", Title: "Keyboard", PriceCents: 4990},
-			"p2": {ID: "p2", Title: "Mouse", PriceCents: 1990},
+			"p1": {ID: "p1", Title: "Keyboard", PriceCents: 4990, InStock: true, CreatedAt: now},
+			"p2": {ID: "p2", Title: "Mouse", PriceCents: 1990, InStock: true, CreatedAt: now},
 		},
 	}
 }
@@ -24,15 +29,40 @@ func (s *MemStore) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (s *MemStore) ListSortedByID(ctx context.Context) ([]Product, error) {
+func (s *MemStore) List(ctx context.Context, q ListQuery) ([]Product, error) {
 	s.mu.RLock()
 	out := make([]Product, 0, len(s.products))
 	for _, p := range s.products {
-		out = append(out, p)
+		if matchesFilter(p, q) {
+			out = append(out, p)
+		}
 	}
 	s.mu.RUnlock()
 
-	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	ordered := func(a, b Product) bool {
+		ca, cb := cursorAfter(a, q.Sort, q.Desc), cursorAfter(b, q.Sort, q.Desc)
+		if q.Desc {
+			return less(cb, ca, q.Sort)
+		}
+		return less(ca, cb, q.Sort)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return ordered(out[i], out[j]) })
+
+	if q.After != nil {
+		n := sort.Search(len(out), func(i int) bool {
+			c := cursorAfter(out[i], q.Sort, q.Desc)
+			if q.Desc {
+				return less(c, *q.After, q.Sort)
+			}
+			return less(*q.After, c, q.Sort)
+		})
+		out = out[n:]
+	}
+
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
 	return out, nil
 }
 
@@ -43,3 +73,167 @@ func (s *MemStore) Get(ctx context.Context, id string) (Product, bool, error) {
 
 	return p, ok, nil
 }
+
+func (s *MemStore) GetMany(ctx context.Context, ids []string) ([]Product, error) {
+	s.mu.RLock()
+	out := make([]Product, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := s.products[id]; ok {
+			out = append(out, p)
+		}
+	}
+	s.mu.RUnlock()
+
+	return out, nil
+}
+
+func (s *MemStore) Create(ctx context.Context, p Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.products[p.ID]; exists {
+		return ErrProductExists
+	}
+
+	p.Available, p.InStock = nil, true
+	s.products[p.ID] = p
+	return nil
+}
+
+func (s *MemStore) Update(ctx context.Context, p Product) (Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, exists := s.products[p.ID]
+	if !exists {
+		return Product{}, ErrProductNotFound
+	}
+
+	p.CreatedAt = cur.CreatedAt
+	p.Available, p.InStock = cur.Available, cur.InStock
+	s.products[p.ID] = p
+	return p, nil
+}
+
+func (s *MemStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.products[id]; !exists {
+		return ErrProductNotFound
+	}
+
+	delete(s.products, id)
+
+	kept := s.movements[:0]
+	for _, m := range s.movements {
+		if m.ProductID != id {
+			kept = append(kept, m)
+		}
+	}
+	s.movements = kept
+	return nil
+}
+
+func (s *MemStore) RecordMovements(ctx context.Context, ms []Movement) ([]Movement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stock := make(map[string]int)
+	var (
+		apply []Movement
+		short []string
+	)
+	for _, m := range ms {
+		p, ok := s.products[m.ProductID]
+		if !ok {
+			return nil, ErrProductNotFound
+		}
+		cur, seen := stock[m.ProductID]
+		if !seen && p.Available == nil && !tracksUntracked(m.Kind) {
+			continue
+		}
+		if s.recorded(m) {
+			continue
+		}
+		if !seen && p.Available != nil {
+			cur = *p.Available
+		}
+		if cur+m.Delta < 0 {
+			short = append(short, m.ProductID)
+			continue
+		}
+		stock[m.ProductID] = cur + m.Delta
+		m.StockAfter = cur + m.Delta
+		apply = append(apply, m)
+	}
+	if len(short) > 0 {
+		return nil, &InsufficientStockError{ProductIDs: short}
+	}
+
+	for i := range apply {
+		s.lastMovementID++
+		apply[i].ID = s.lastMovementID
+		s.movements = append(s.movements, apply[i])
+	}
+	for id, n := range stock {
+		p := s.products[id]
+		p.Available = &n
+		p.InStock = inStock(p.Available)
+		s.products[id] = p
+	}
+	return apply, nil
+}
+
+func (s *MemStore) recorded(m Movement) bool {
+	if m.Reference == "" {
+		return false
+	}
+	for _, r := range s.movements {
+		if r.Kind == m.Kind && r.Reference == m.Reference && r.ProductID == m.ProductID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) ListMovements(ctx context.Context, q MovementQuery) ([]Movement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.products[q.ProductID]; !ok {
+		return nil, ErrProductNotFound
+	}
+
+	var out []Movement
+	for i := len(s.movements) - 1; i >= 0; i-- {
+		m := s.movements[i]
+		if m.ProductID != q.ProductID || (q.BeforeID > 0 && m.ID >= q.BeforeID) {
+			continue
+		}
+		out = append(out, m)
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (s *MemStore) StockSummary(ctx context.Context, lowThreshold int) (StockSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sum StockSummary
+	for _, p := range s.products {
+		if p.Available == nil {
+			continue
+		}
+		if *p.Available <= lowThreshold {
+			sum.Low++
+		}
+		if *p.Available == 0 {
+			sum.OutOfStock++
+		}
+	}
+	return sum, nil
+}
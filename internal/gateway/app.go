@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -31,6 +32,18 @@ type Deps struct {
 	CatalogURL string
 	OrderURL   string
 	JWTSecret  string
+	JWKSURL    string
+
+	IdentitySecret string
+
+	// RevocationsURL points at auth's /internal/revocations; when set, revoked
+	// access tokens are rejected within RevocationsPoll. The list is fetched
+	// with a token for the ServiceClientID service account.
+	RevocationsURL  string
+	RevocationsPoll time.Duration
+
+	ServiceClientID     string
+	ServiceClientSecret string
 }
 
 const (
@@ -52,17 +65,35 @@ func NewHandler(deps Deps, httpDeps HTTPDeps) (http.Handler, error) {
 		return nil, err
 	}
 
-	jwt := auth.NewTokenMaker(deps.JWTSecret)
+	jwt := auth.NewTokenVerifier(deps.JWKSURL, deps.JWTSecret)
+	if deps.RevocationsURL != "" {
+		if deps.ServiceClientID == "" || deps.ServiceClientSecret == "" {
+			return nil, errors.New("revocations need service client credentials")
+		}
+		tokens := auth.NewServiceTokenSource(deps.AuthURL+"/auth/token", deps.ServiceClientID, deps.ServiceClientSecret)
+		rc := auth.NewRevocationClient(deps.RevocationsURL, tokens)
+		if deps.RevocationsPoll > 0 {
+			rc.Poll = deps.RevocationsPoll
+		}
+		jwt.WithRevocations(rc)
+	}
 
 	r := chi.NewRouter()
 	setupMiddleware(r, httpDeps)
 	setupMetrics(r, httpDeps)
+	r.Use(EnforcePolicies(jwt, DefaultPolicies))
 
 	r.Get("/healthz", healthz)
 	r.Get("/readyz", readyz(deps, httpDeps.Log))
 
 	r.Handle("/auth", authProxy)
 	r.Handle("/auth/*", authProxy)
+	r.Handle("/.well-known/jwks.json", authProxy)
+	r.Handle("/.well-known/openid-configuration", authProxy)
+
+	privacy := newPrivacyHandler(deps, httpDeps.Log)
+	r.Get("/admin/users/{id}/export", privacy.handleExport)
+	r.Post("/admin/users/{id}/erase", privacy.handleErase)
 
 	r.Handle("/products", catalogProxy)
 	r.Handle("/products/*", catalogProxy)
@@ -77,17 +108,19 @@ func NewHandler(deps Deps, httpDeps HTTPDeps) (http.Handler, error) {
 }
 
 func buildProxies(deps Deps, log *zap.Logger) (authProxy, catalogProxy, orderProxy http.Handler, err error) {
-	ap, err := NewReverseProxy(deps.AuthURL, log)
+	secret := []byte(deps.IdentitySecret)
+
+	ap, err := NewReverseProxy(deps.AuthURL, secret, log)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	cp, err := NewReverseProxy(deps.CatalogURL, log)
+	cp, err := NewReverseProxy(deps.CatalogURL, secret, log)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	op, err := NewReverseProxy(deps.OrderURL, log)
+	op, err := NewReverseProxy(deps.OrderURL, secret, log)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -99,6 +132,7 @@ func setupMiddleware(r *chi.Mux, deps HTTPDeps) {
 	r.Use(chimw.RequestID)
 	r.Use(kit.Recoverer)
 	r.Use(kit.Logging(deps.Log))
+	r.Use(StripIdentityHeaders)
 }
 
 func setupMetrics(r *chi.Mux, deps HTTPDeps) {
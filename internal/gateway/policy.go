@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"MiniStore/internal/auth"
+	"MiniStore/pkg/kit"
+)
+
+// RoutePolicy admits a request when the caller's role has any of
+// Permissions; the upstream service still enforces the exact permission.
+type RoutePolicy struct {
+	Prefix      string
+	Methods     []string
+	Permissions []string
+}
+
+var writeMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+var DefaultPolicies = []RoutePolicy{
+	{Prefix: "/products", Methods: writeMethods, Permissions: []string{kit.PermProductsWrite}},
+	{Prefix: "/auth/admin/service-accounts", Permissions: []string{kit.PermServiceAccounts}},
+	{Prefix: "/auth/admin/clients", Permissions: []string{kit.PermOAuthClients}},
+	{Prefix: "/auth/audit", Permissions: []string{kit.PermAuditRead}},
+	{Prefix: "/admin/users", Permissions: []string{kit.PermPersonalData}},
+	{Prefix: "/auth/admin", Permissions: []string{kit.PermUsersRead, kit.PermUsersRolesWrite, kit.PermUsersWrite}},
+}
+
+func (p RoutePolicy) matches(r *http.Request) bool {
+	path := r.URL.Path
+	if path != p.Prefix && !strings.HasPrefix(path, p.Prefix+"/") {
+		return false
+	}
+	if len(p.Methods) == 0 {
+		return true
+	}
+	for _, m := range p.Methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	return false
+}
+
+func EnforcePolicies(jwt *auth.TokenMaker, policies []RoutePolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var perms []string
+			for _, p := range policies {
+				if p.matches(r) {
+					perms = p.Permissions
+					break
+				}
+			}
+			if len(perms) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tok, ok := bearerToken(r)
+			if !ok {
+				kit.WriteError(w, r, http.StatusUnauthorized, "missing token", nil)
+				return
+			}
+
+			claims, err := jwt.Parse(tok)
+			if err != nil || claims.UserID == "" {
+				kit.WriteError(w, r, http.StatusUnauthorized, "invalid token", nil)
+				return
+			}
+
+			if !claims.Principal().CanAny(perms...) {
+				kit.WriteError(w, r, http.StatusForbidden, "forbidden", map[string]any{"permissions": perms})
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(withAuthContext(r.Context(), claims)))
+		})
+	}
+}
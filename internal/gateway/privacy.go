@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	privacyTimeout  = 10 * time.Second
+	maxExportBytes  = 32 << 20
+	accountErased   = "anonymized"
+	accountNotFound = "not_found"
+)
+
+var errExportTooLarge = errors.New("upstream response exceeds export limit")
+
+// privacyHandler lives in the gateway because it is the only place that can
+// reach both auth and order.
+type privacyHandler struct {
+	authURL  string
+	orderURL string
+	secret   []byte
+	client   *http.Client
+	log      *zap.Logger
+}
+
+func newPrivacyHandler(deps Deps, log *zap.Logger) *privacyHandler {
+	return &privacyHandler{
+		authURL:  deps.AuthURL,
+		orderURL: deps.OrderURL,
+		secret:   []byte(deps.IdentitySecret),
+		client:   &http.Client{Timeout: privacyTimeout, Transport: newProxyTransport()},
+		log:      log,
+	}
+}
+
+type userArchive struct {
+	UserID     string            `json:"user_id"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Auth       json.RawMessage   `json:"auth"`
+	Orders     []json.RawMessage `json:"orders"`
+}
+
+type ordersPage struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+type orderErasure struct {
+	Pseudonym string `json:"pseudonym"`
+	Orders    int    `json:"orders"`
+}
+
+type erasureResp struct {
+	UserID         string `json:"user_id"`
+	Account        string `json:"account"`
+	Orders         int    `json:"orders"`
+	OrderPseudonym string `json:"order_pseudonym"`
+}
+
+// A deleted account still exports its orders.
+func (h *privacyHandler) handleExport(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	path := "/internal/users/" + url.PathEscape(id)
+
+	status, authRaw, err := h.call(r, http.MethodGet, h.authURL+path+"/export")
+	if err != nil {
+		h.upstreamError(w, r, "auth", err)
+		return
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		authRaw = []byte("null")
+	default:
+		h.upstreamError(w, r, "auth", fmt.Errorf("status=%d", status))
+		return
+	}
+
+	status, ordersRaw, err := h.call(r, http.MethodGet, h.orderURL+path+"/orders")
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status=%d", status)
+	}
+	var orders ordersPage
+	if err == nil {
+		err = json.Unmarshal(ordersRaw, &orders)
+	}
+	if err != nil {
+		h.upstreamError(w, r, "order", err)
+		return
+	}
+
+	if string(authRaw) == "null" && len(orders.Items) == 0 {
+		kit.WriteError(w, r, http.StatusNotFound, "user not found", map[string]any{"id": id})
+		return
+	}
+	if orders.Items == nil {
+		orders.Items = []json.RawMessage{}
+	}
+
+	h.logAction(r, "personal data exported", id)
+	w.Header().Set("Content-Disposition", `attachment; filename="user-`+url.PathEscape(id)+`.json"`)
+	kit.WriteJSON(w, http.StatusOK, userArchive{
+		UserID:     id,
+		ExportedAt: time.Now().UTC(),
+		Auth:       authRaw,
+		Orders:     orders.Items,
+	})
+}
+
+// Both steps are idempotent, so a failed run can simply be retried.
+func (h *privacyHandler) handleErase(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	path := "/internal/users/" + url.PathEscape(id)
+
+	status, raw, err := h.call(r, http.MethodPost, h.orderURL+path+"/erase")
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status=%d", status)
+	}
+	var oe orderErasure
+	if err == nil {
+		err = json.Unmarshal(raw, &oe)
+	}
+	if err != nil {
+		h.upstreamError(w, r, "order", err)
+		return
+	}
+
+	status, _, err = h.call(r, http.MethodPost, h.authURL+path+"/erase")
+	if err != nil {
+		h.upstreamError(w, r, "auth", err)
+		return
+	}
+	account := accountErased
+	switch status {
+	case http.StatusNoContent:
+	case http.StatusNotFound:
+		account = accountNotFound
+	default:
+		h.upstreamError(w, r, "auth", fmt.Errorf("status=%d", status))
+		return
+	}
+
+	h.logAction(r, "personal data erased", id)
+	kit.WriteJSON(w, http.StatusOK, erasureResp{
+		UserID:         id,
+		Account:        account,
+		Orders:         oe.Orders,
+		OrderPseudonym: oe.Pseudonym,
+	})
+}
+
+// call forwards the bearer token and a signed identity, so it works with
+// either AUTH_MODE.
+func (h *privacyHandler) call(r *http.Request, method, target string) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), privacyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	reqID := chimw.GetReqID(r.Context())
+	if reqID != "" {
+		req.Header.Set(kit.HeaderRequestID, reqID)
+	}
+	if id, ok := authFromContext(r.Context()); ok && len(h.secret) > 0 {
+		id.RequestID = reqID
+		kit.SignIdentity(req, id, h.secret, time.Now())
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	// One byte past the limit tells a cut-off archive from a complete one.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExportBytes+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) > maxExportBytes {
+		return 0, nil, fmt.Errorf("%w: %d bytes", errExportTooLarge, maxExportBytes)
+	}
+	return resp.StatusCode, body, nil
+}
+
+func (h *privacyHandler) upstreamError(w http.ResponseWriter, r *http.Request, service string, err error) {
+	if h.log != nil {
+		h.log.Warn("privacy request failed", zap.String("service", service), zap.String("path", r.URL.Path), zap.Error(err))
+	}
+	if isTimeoutErr(err) {
+		kit.WriteError(w, r, http.StatusGatewayTimeout, "upstream timeout", map[string]any{"service": service})
+		return
+	}
+	kit.WriteError(w, r, http.StatusBadGateway, "bad gateway", map[string]any{"service": service})
+}
+
+func (h *privacyHandler) logAction(r *http.Request, msg, userID string) {
+	if p, ok := kit.PrincipalFromContext(r.Context()); ok && h.log != nil {
+		h.log.Info(msg, zap.String("actor_id", p.UserID), zap.String("user_id", userID))
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPrivacyCallRejectsOversizedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		_, _ = w.Write([]byte(strings.Repeat("a", n)))
+	}))
+	defer srv.Close()
+
+	h := &privacyHandler{client: srv.Client()}
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"empty", 0, nil},
+		{"at limit", maxExportBytes, nil},
+		{"over limit", maxExportBytes + 1, errExportTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/users/u1/export", nil)
+			status, body, err := h.call(r, http.MethodGet, srv.URL+"?n="+strconv.Itoa(tt.size))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err=%v want=%v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (status != http.StatusOK || len(body) != tt.size) {
+				t.Fatalf("status=%d len=%d want len=%d", status, len(body), tt.size)
+			}
+		})
+	}
+}
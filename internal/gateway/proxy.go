@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	chimw "github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 
 	"MiniStore/internal/auth"
@@ -19,8 +20,7 @@ import (
 type ctxKey string
 
 const (
-	userIDKey   ctxKey = "user_id"
-	userRoleKey ctxKey = "user_role"
+	identityKey ctxKey = "identity"
 
 	bearerPrefix = "Bearer "
 )
@@ -40,7 +40,7 @@ func AuthJWT(jwt *auth.TokenMaker) func(http.Handler) http.Handler {
 				return
 			}
 
-			ctx := withAuthContext(r.Context(), claims.UserID, claims.Role)
+			ctx := withAuthContext(r.Context(), claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -60,19 +60,57 @@ func bearerToken(r *http.Request) (string, bool) {
 	return tok, true
 }
 
-func withAuthContext(ctx context.Context, userID, role string) context.Context {
-	ctx = context.WithValue(ctx, userIDKey, userID)
-	ctx = context.WithValue(ctx, userRoleKey, role)
-	return ctx
+func withAuthContext(ctx context.Context, c auth.Claims) context.Context {
+	ctx = context.WithValue(ctx, identityKey, kit.Identity{
+		UserID:        c.UserID,
+		Role:          c.Role,
+		EmailVerified: c.EmailVerified,
+		Scopes:        c.Scopes(),
+	})
+	return kit.WithPrincipal(ctx, c.Principal())
 }
 
-func NewReverseProxy(target string, log *zap.Logger) (*httputil.ReverseProxy, error) {
+func authFromContext(ctx context.Context) (kit.Identity, bool) {
+	id, ok := ctx.Value(identityKey).(kit.Identity)
+	return id, ok && id.UserID != ""
+}
+
+func StripIdentityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kit.StripIdentity(r.Header)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func forwardIdentity(p *httputil.ReverseProxy, secret []byte) {
+	director := p.Director
+	p.Director = func(r *http.Request) {
+		director(r)
+		kit.StripIdentity(r.Header)
+
+		reqID := chimw.GetReqID(r.Context())
+		if reqID != "" {
+			r.Header.Set(kit.HeaderRequestID, reqID)
+		}
+
+		id, ok := authFromContext(r.Context())
+		if !ok || len(secret) == 0 {
+			return
+		}
+
+		id.RequestID = reqID
+		kit.SignIdentity(r, id, secret, time.Now())
+	}
+}
+
+func NewReverseProxy(target string, identitySecret []byte, log *zap.Logger) (*httputil.ReverseProxy, error) {
 	u, err := url.Parse(target)
 	if err != nil {
 		return nil, err
 	}
 
 	p := httputil.NewSingleHostReverseProxy(u)
+	forwardIdentity(p, identitySecret)
 	p.Transport = newProxyTransport()
 	p.ErrorHandler = proxyErrorHandler(target, log)
 	p.ModifyResponse = proxyModifyResponse(target, log)
@@ -2,18 +2,36 @@ package gateway_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 
 	"MiniStore/internal/auth"
 	"MiniStore/internal/catalog"
 	"MiniStore/internal/gateway"
 	"MiniStore/internal/order"
+	"MiniStore/pkg/kit"
 )
 
 const jwtSecret = "test-secret-32-chars-minimum-........"
@@ -26,14 +44,16 @@ type testEnv struct {
 	Client  *http.Client
 }
 
-func newAuthTS(t *testing.T, jwtSecret string) *httptest.Server {
-	t.Helper()
-
-	s := &auth.Server{
+func newAuthServer(jwtSecret string) *auth.Server {
+	return &auth.Server{
 		Log:   zap.NewNop(),
 		Store: auth.NewMemStore(),
 		JWT:   auth.NewTokenMaker(jwtSecret),
 	}
+}
+
+func newAuthTS(t *testing.T, s *auth.Server) *httptest.Server {
+	t.Helper()
 
 	h := auth.NewHandler(s, auth.HTTPDeps{
 		Log:     zap.NewNop(),
@@ -43,12 +63,13 @@ func newAuthTS(t *testing.T, jwtSecret string) *httptest.Server {
 	return httptest.NewServer(h)
 }
 
-func newCatalogTS(t *testing.T) *httptest.Server {
+func newCatalogTS(t *testing.T, jwtSecret string) *httptest.Server {
 	t.Helper()
 
 	s := &catalog.Server{
 		Store: catalog.NewMemStore(),
 		Log:   zap.NewNop(),
+		JWT:   auth.NewTokenMaker(jwtSecret),
 	}
 
 	h := catalog.NewHandler(s, catalog.HTTPDeps{
@@ -59,12 +80,13 @@ func newCatalogTS(t *testing.T) *httptest.Server {
 	return httptest.NewServer(h)
 }
 
-func newOrderTS(t *testing.T, jwtSecret, catalogURL string) *httptest.Server {
+func newOrderTS(t *testing.T, jwtSecret, authURL, catalogURL string, requireVerified bool) *httptest.Server {
 	t.Helper()
 
+	clientID, clientSecret := newServiceClient(t, authURL, kit.PermStockOrders)
 	s := &order.Server{
 		Store:   order.NewMemStore(),
-		Catalog: order.NewCatalogClient(catalogURL),
+		Catalog: order.NewCatalogClient(catalogURL, auth.NewServiceTokenSource(authURL+"/auth/token", clientID, clientSecret)),
 		Log:     zap.NewNop(),
 	}
 
@@ -72,6 +94,8 @@ func newOrderTS(t *testing.T, jwtSecret, catalogURL string) *httptest.Server {
 		Log:       zap.NewNop(),
 		Service:   "order",
 		JWTSecret: jwtSecret,
+
+		RequireVerifiedEmail: requireVerified,
 	})
 
 	return httptest.NewServer(h)
@@ -80,12 +104,17 @@ func newOrderTS(t *testing.T, jwtSecret, catalogURL string) *httptest.Server {
 func newGatewayTS(t *testing.T, jwtSecret, authURL, catalogURL, orderURL string) *httptest.Server {
 	t.Helper()
 
+	clientID, clientSecret := newServiceClient(t, authURL, kit.PermRevocationsRead)
 	h, err := gateway.NewHandler(
 		gateway.Deps{
-			JWTSecret:  jwtSecret,
-			AuthURL:    authURL,
-			CatalogURL: catalogURL,
-			OrderURL:   orderURL,
+			JWTSecret:           jwtSecret,
+			AuthURL:             authURL,
+			CatalogURL:          catalogURL,
+			OrderURL:            orderURL,
+			RevocationsURL:      authURL + "/internal/revocations",
+			RevocationsPoll:     10 * time.Millisecond,
+			ServiceClientID:     clientID,
+			ServiceClientSecret: clientSecret,
 		},
 		gateway.HTTPDeps{
 			Log:     zap.NewNop(),
@@ -99,16 +128,58 @@ func newGatewayTS(t *testing.T, jwtSecret, authURL, catalogURL, orderURL string)
 	return httptest.NewServer(h)
 }
 
+// newServiceClient registers a service account directly with auth and returns
+// its client credentials.
+func newServiceClient(t *testing.T, authURL string, scopes ...string) (id, secret string) {
+	t.Helper()
+
+	c := &http.Client{}
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_bootstrap", "admin")}
+
+	resp, raw := doJSON(t, c, http.MethodPost, authURL+"/auth/admin/service-accounts", map[string]any{"name": "internal"}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+	var sa struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &sa); err != nil {
+		t.Fatalf("decode service account: %v body=%s", err, string(raw))
+	}
+
+	resp, raw = doJSON(t, c, http.MethodPost, authURL+"/auth/admin/service-accounts/"+sa.ID+"/keys", map[string]any{"scopes": scopes}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+	var key struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(raw, &key); err != nil {
+		t.Fatalf("decode api key: %v body=%s", err, string(raw))
+	}
+	return sa.ID, key.Key
+}
+
+type envOptions struct {
+	Auth                 *auth.Server
+	RequireVerifiedEmail bool
+}
+
 func newTestEnv(t *testing.T) testEnv {
 	t.Helper()
+	return newTestEnvWith(t, envOptions{})
+}
+
+func newTestEnvWith(t *testing.T, opts envOptions) testEnv {
+	t.Helper()
 
-	authTS := newAuthTS(t, jwtSecret)
+	if opts.Auth == nil {
+		opts.Auth = newAuthServer(jwtSecret)
+	}
+
+	authTS := newAuthTS(t, opts.Auth)
 	t.Cleanup(authTS.Close)
 
-	catalogTS := newCatalogTS(t)
+	catalogTS := newCatalogTS(t, jwtSecret)
 	t.Cleanup(catalogTS.Close)
 
-	orderTS := newOrderTS(t, jwtSecret, catalogTS.URL)
+	orderTS := newOrderTS(t, jwtSecret, authTS.URL, catalogTS.URL, opts.RequireVerifiedEmail)
 	t.Cleanup(orderTS.Close)
 
 	gwTS := newGatewayTS(t, jwtSecret, authTS.URL, catalogTS.URL, orderTS.URL)
@@ -218,6 +289,33 @@ func createOrder(t *testing.T, env testEnv, token string, items []map[string]any
 	return created
 }
 
+func issueToken(t *testing.T, userID, role string) string {
+	t.Helper()
+	tok, err := auth.NewTokenMaker(jwtSecret).New(userID, userID+"@example.com", role, time.Minute)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	return tok
+}
+
+// staticToken is a TokenSource for tests that have no auth service to get a
+// client-credentials token from.
+type staticToken string
+
+func (s staticToken) Token(context.Context) (string, error) { return string(s), nil }
+
+// stockToken is a service token the catalog accepts for order stock moves.
+func stockToken(t *testing.T) staticToken {
+	t.Helper()
+	tok, err := auth.NewTokenMaker(jwtSecret).Issue(auth.Claims{
+		UserID: "sa_order", Role: kit.RoleService, Scope: kit.PermStockOrders,
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue service token: %v", err)
+	}
+	return staticToken(tok)
+}
+
 func getOrder(t *testing.T, env testEnv, token, id string) order.Order {
 	t.Helper()
 	resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders/"+id, nil, map[string]string{
@@ -267,6 +365,16 @@ func TestGateway_PublicAPI_HappyPath(t *testing.T) {
 	if got.TotalCents != created.TotalCents {
 		t.Fatalf("total=%d want=%d", got.TotalCents, created.TotalCents)
 	}
+
+	wantLines := map[string]int64{"p1": 9980, "p2": 1990}
+	for _, it := range got.Items {
+		if it.Title == nil || it.UnitPriceCents == nil || it.LineTotalCents == nil {
+			t.Fatalf("item %s missing snapshot: %+v", it.ProductID, it)
+		}
+		if *it.LineTotalCents != wantLines[it.ProductID] || *it.UnitPriceCents*int64(it.Qty) != *it.LineTotalCents {
+			t.Fatalf("item %s line_total=%d unit=%d qty=%d", it.ProductID, *it.LineTotalCents, *it.UnitPriceCents, it.Qty)
+		}
+	}
 }
 
 func TestGateway_PublicAPI_OrdersRequiresAuth(t *testing.T) {
@@ -303,3 +411,2233 @@ func TestGateway_PublicAPI_InvalidTokenRejected(t *testing.T) {
 
 	mustStatus(t, resp, raw, http.StatusUnauthorized)
 }
+
+func TestGateway_PublicAPI_ProductAdmin(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	user := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_user", "user")}
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": 19990,
+	}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": 19990,
+	}, user)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": -1,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": 19990,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": 19990,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusConflict)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPatch, env.GW.URL+"/products/p3", map[string]any{
+		"price_cents": 17990,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	var p catalog.Product
+	if err := json.Unmarshal(raw, &p); err != nil {
+		t.Fatalf("decode product: %v body=%s", err, string(raw))
+	}
+	if p.Title != "Monitor" || p.PriceCents != 17990 {
+		t.Fatalf("product=%+v", p)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, env.GW.URL+"/products/p404", map[string]any{
+		"title": "Ghost", "price_cents": 1,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	resp, raw = doJSON(t, env.Client, http.MethodDelete, env.GW.URL+"/products/p3", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/products/p3", nil, nil)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+}
+
+func TestGateway_PublicAPI_ProductsPagination(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	for _, p := range []map[string]any{
+		{"id": "p3", "title": "Monitor", "price_cents": 19990},
+		{"id": "p4", "title": "Mousepad", "price_cents": 990},
+		{"id": "p5", "title": "Headset", "price_cents": 7990},
+		{"id": "p6", "title": "Cable", "price_cents": 990},
+	} {
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", p, admin)
+		mustStatus(t, resp, raw, http.StatusCreated)
+	}
+
+	type page struct {
+		Items      []catalog.Product `json:"items"`
+		NextCursor string            `json:"next_cursor"`
+	}
+	fetch := func(query string) page {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/products?"+query, nil, nil)
+		mustStatus(t, resp, raw, http.StatusOK)
+
+		var pg page
+		if err := json.Unmarshal(raw, &pg); err != nil {
+			t.Fatalf("decode page: %v body=%s", err, string(raw))
+		}
+		return pg
+	}
+
+	// Walking the pages one sort at a time; equal prices are ordered by id,
+	// so p4 and p6 come out the same way on every page boundary.
+	collect := func(query string) string {
+		t.Helper()
+		var ids []string
+		q := query
+		for i := 0; ; i++ {
+			if i > 6 {
+				t.Fatalf("pagination did not terminate")
+			}
+			pg := fetch(q)
+			for _, p := range pg.Items {
+				ids = append(ids, p.ID)
+			}
+			if pg.NextCursor == "" {
+				break
+			}
+			q = query + "&cursor=" + pg.NextCursor
+		}
+		return strings.Join(ids, ",")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"limit=2&sort=-price", "p3,p5,p1,p2,p6,p4"},
+		{"limit=1&sort=price", "p4,p6,p2,p1,p5,p3"},
+		{"limit=4", "p1,p2,p3,p4,p5,p6"},
+		{"limit=2&sort=-id", "p6,p5,p4,p3,p2,p1"},
+	}
+	for _, tt := range tests {
+		if got := collect(tt.query); got != tt.want {
+			t.Fatalf("%s: ids=%s want=%s", tt.query, got, tt.want)
+		}
+	}
+
+	pg := fetch("title_prefix=mo&min_price=1000")
+	if len(pg.Items) != 2 || pg.Items[0].ID != "p2" || pg.Items[1].ID != "p3" {
+		t.Fatalf("filtered=%+v", pg.Items)
+	}
+
+	// A cursor only continues the listing it came from.
+	priceCursor := fetch("limit=1&sort=price").NextCursor
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, q := range []string{
+		"sort=id&cursor=" + priceCursor,
+		"sort=-price&cursor=" + priceCursor,
+		"cursor=bogus!",
+		"cursor=" + enc("p1"),
+		"cursor=" + enc(`{"s":"price","p":100}`),
+		"cursor=" + enc(`{"s":"id","id":7}`),
+	} {
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/products?"+q, nil, nil)
+		mustStatus(t, resp, raw, http.StatusBadRequest)
+	}
+}
+
+func TestGateway_PublicAPI_OrderReportsAllMissingProducts(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	user := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_buyer", "user")}
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", map[string]any{
+		"items": []map[string]any{
+			{"product_id": "p1", "qty": 1},
+			{"product_id": "nope1", "qty": 1},
+			{"product_id": "nope2", "qty": 3},
+		},
+	}, user)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	var er struct {
+		Details struct {
+			ProductIDs []string `json:"product_ids"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(raw, &er); err != nil {
+		t.Fatalf("decode error: %v body=%s", err, string(raw))
+	}
+	if strings.Join(er.Details.ProductIDs, ",") != "nope1,nope2" {
+		t.Fatalf("product_ids=%v", er.Details.ProductIDs)
+	}
+}
+
+func TestGateway_PublicAPI_OrderStatusTransitions(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	owner := issueToken(t, "u_owner", "user")
+	other := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_other", "user")}
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+
+	paid := createOrder(t, env, owner, []map[string]any{{"product_id": "p1", "qty": 1}})
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+paid.ID+"/pay", nil, other)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+paid.ID+"/pay", nil, map[string]string{
+		"Authorization": "Bearer " + owner,
+	})
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	if got := getOrder(t, env, owner, paid.ID); got.Status != order.StatusPaid {
+		t.Fatalf("status=%s want=%s", got.Status, order.StatusPaid)
+	}
+
+	cancelled := createOrder(t, env, owner, []map[string]any{{"product_id": "p2", "qty": 1}})
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+cancelled.ID+"/cancel", nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	// PAID and CANCELLED are final.
+	for _, tt := range []struct{ id, action string }{
+		{paid.ID, "pay"},
+		{paid.ID, "cancel"},
+		{cancelled.ID, "pay"},
+		{cancelled.ID, "cancel"},
+	} {
+		resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+tt.id+"/"+tt.action, nil, admin)
+		mustStatus(t, resp, raw, http.StatusConflict)
+	}
+
+	if got := getOrder(t, env, owner, cancelled.ID); got.Status != order.StatusCancelled {
+		t.Fatalf("status=%s want=%s", got.Status, order.StatusCancelled)
+	}
+}
+
+func TestGateway_PublicAPI_ListOrders(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	owner := issueToken(t, "u_lister", "user")
+	other := issueToken(t, "u_stranger", "user")
+	hdr := map[string]string{"Authorization": "Bearer " + owner}
+
+	var want []string
+	for i := 0; i < 3; i++ {
+		o := createOrder(t, env, owner, []map[string]any{{"product_id": "p1", "qty": i + 1}})
+		want = append([]string{o.ID}, want...)
+	}
+	createOrder(t, env, other, []map[string]any{{"product_id": "p2", "qty": 1}})
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+want[0]+"/cancel", nil, hdr)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	type page struct {
+		Items      []order.Order `json:"items"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	fetch := func(query string) page {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders?"+query, nil, hdr)
+		mustStatus(t, resp, raw, http.StatusOK)
+
+		var pg page
+		if err := json.Unmarshal(raw, &pg); err != nil {
+			t.Fatalf("decode page: %v body=%s", err, string(raw))
+		}
+		return pg
+	}
+
+	first := fetch("limit=2")
+	second := fetch("limit=2&cursor=" + first.NextCursor)
+	if second.NextCursor != "" {
+		t.Fatalf("unexpected next_cursor on last page")
+	}
+
+	var got []string
+	for _, o := range append(first.Items, second.Items...) {
+		if len(o.Items) == 0 {
+			t.Fatalf("order %s has no items", o.ID)
+		}
+		got = append(got, o.ID)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("ids=%v want=%v", got, want)
+	}
+
+	cancelled := fetch("status=CANCELLED")
+	if len(cancelled.Items) != 1 || cancelled.Items[0].ID != want[0] {
+		t.Fatalf("cancelled=%+v", cancelled.Items)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders?status=SHIPPED", nil, hdr)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, c := range []string{
+		"bogus!",
+		enc("o_1"),
+		enc(`{"c":"2026-01-01T00:00:00Z"}`),
+		enc(`{"c":"yesterday","id":"o_1"}`),
+	} {
+		resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders?cursor="+c, nil, hdr)
+		mustStatus(t, resp, raw, http.StatusBadRequest)
+	}
+}
+
+func TestGateway_PublicAPI_CreateOrderIdempotent(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	hdr := map[string]string{
+		"Authorization":   "Bearer " + issueToken(t, "u_retry", "user"),
+		"Idempotency-Key": "k-1",
+	}
+	body := map[string]any{"items": []map[string]any{{"product_id": "p1", "qty": 1}}}
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", body, hdr)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	var first order.Order
+	if err := json.Unmarshal(raw, &first); err != nil {
+		t.Fatalf("decode order: %v body=%s", err, string(raw))
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", body, hdr)
+	mustStatus(t, resp, raw, http.StatusCreated)
+	if resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replayed response")
+	}
+
+	var replayed order.Order
+	if err := json.Unmarshal(raw, &replayed); err != nil {
+		t.Fatalf("decode order: %v body=%s", err, string(raw))
+	}
+	if replayed.ID != first.ID {
+		t.Fatalf("replayed id=%s want=%s", replayed.ID, first.ID)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", map[string]any{
+		"items": []map[string]any{{"product_id": "p2", "qty": 1}},
+	}, hdr)
+	mustStatus(t, resp, raw, http.StatusUnprocessableEntity)
+}
+
+func TestGateway_PublicAPI_RefreshAndLogout(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	email := "refresh@example.com"
+	pass := "password123"
+	register(t, env, email, pass)
+
+	type tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	post := func(path string, body any, want int) tokens {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+path, body, nil)
+		mustStatus(t, resp, raw, want)
+
+		var tk tokens
+		if want == http.StatusOK {
+			if err := json.Unmarshal(raw, &tk); err != nil {
+				t.Fatalf("decode tokens: %v body=%s", err, string(raw))
+			}
+			if tk.AccessToken == "" || tk.RefreshToken == "" {
+				t.Fatalf("empty tokens: %s", string(raw))
+			}
+		}
+		return tk
+	}
+
+	first := post("/auth/login", map[string]any{"email": email, "password": pass}, http.StatusOK)
+	second := post("/auth/refresh", map[string]any{"refresh_token": first.RefreshToken}, http.StatusOK)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token was not rotated")
+	}
+
+	post("/auth/refresh", map[string]any{"refresh_token": first.RefreshToken}, http.StatusUnauthorized)
+	post("/auth/refresh", map[string]any{"refresh_token": second.RefreshToken}, http.StatusUnauthorized)
+
+	other := post("/auth/login", map[string]any{"email": email, "password": pass}, http.StatusOK)
+	post("/auth/logout", map[string]any{"refresh_token": other.RefreshToken}, http.StatusNoContent)
+	post("/auth/refresh", map[string]any{"refresh_token": other.RefreshToken}, http.StatusUnauthorized)
+}
+
+func newJWKSTestEnv(t *testing.T, ks *auth.KeySet) testEnv {
+	t.Helper()
+
+	authTS := httptest.NewServer(auth.NewHandler(&auth.Server{
+		Log:   zap.NewNop(),
+		Store: auth.NewMemStore(),
+		JWT:   auth.NewKeySetTokenMaker(ks),
+	}, auth.HTTPDeps{Log: zap.NewNop(), Service: "auth"}))
+	t.Cleanup(authTS.Close)
+
+	jwksURL := authTS.URL + "/.well-known/jwks.json"
+
+	catalogTS := newCatalogTS(t, jwtSecret)
+	t.Cleanup(catalogTS.Close)
+
+	orderTS := httptest.NewServer(order.NewHandler(&order.Server{
+		Store:   order.NewMemStore(),
+		Catalog: order.NewCatalogClient(catalogTS.URL, stockToken(t)),
+		Log:     zap.NewNop(),
+	}, order.HTTPDeps{Log: zap.NewNop(), Service: "order", JWKSURL: jwksURL}))
+	t.Cleanup(orderTS.Close)
+
+	h, err := gateway.NewHandler(
+		gateway.Deps{
+			JWKSURL:    jwksURL,
+			AuthURL:    authTS.URL,
+			CatalogURL: catalogTS.URL,
+			OrderURL:   orderTS.URL,
+		},
+		gateway.HTTPDeps{Log: zap.NewNop(), Service: "gateway"},
+	)
+	if err != nil {
+		t.Fatalf("gateway.NewHandler: %v", err)
+	}
+	gwTS := httptest.NewServer(h)
+	t.Cleanup(gwTS.Close)
+
+	return testEnv{Auth: authTS, Catalog: catalogTS, Order: orderTS, GW: gwTS, Client: &http.Client{}}
+}
+
+func newEd25519KeySet(t *testing.T, active string, kids ...string) *auth.KeySet {
+	t.Helper()
+
+	ks := auth.NewKeySet()
+	for _, kid := range kids {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		if err := ks.AddSigner(kid, priv); err != nil {
+			t.Fatalf("add signer: %v", err)
+		}
+	}
+	if err := ks.SetActive(active); err != nil {
+		t.Fatalf("set active: %v", err)
+	}
+	return ks
+}
+
+func TestGateway_PublicAPI_JWKSVerification(t *testing.T) {
+	t.Parallel()
+
+	ks := newEd25519KeySet(t, "k2", "k1", "k2")
+	env := newJWKSTestEnv(t, ks)
+
+	resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/.well-known/jwks.json", nil, nil)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	var set auth.JWKS
+	if err := json.Unmarshal(raw, &set); err != nil {
+		t.Fatalf("decode jwks: %v body=%s", err, string(raw))
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "k1" || set.Keys[1].Kid != "k2" || set.Keys[0].Alg != auth.AlgEdDSA {
+		t.Fatalf("jwks=%+v", set.Keys)
+	}
+
+	email := "jwks@example.com"
+	pass := "password123"
+	register(t, env, email, pass)
+	token := login(t, env, email, pass)
+
+	createOrder(t, env, token, []map[string]any{{"product_id": "p1", "qty": 1}})
+
+	if err := ks.SetActive("k1"); err != nil {
+		t.Fatalf("set active: %v", err)
+	}
+	oldKeyToken, err := auth.NewKeySetTokenMaker(ks).New("u_rotated", "rotated@example.com", "user", time.Minute)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	createOrder(t, env, oldKeyToken, []map[string]any{{"product_id": "p2", "qty": 1}})
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders", nil, map[string]string{
+		"Authorization": "Bearer " + issueToken(t, "u_hs256", "user"),
+	})
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+}
+
+func TestGateway_PublicAPI_ForwardsSignedIdentity(t *testing.T) {
+	t.Parallel()
+
+	const identitySecret = "identity-secret-32-chars-minimum...."
+
+	catalogTS := newCatalogTS(t, jwtSecret)
+	t.Cleanup(catalogTS.Close)
+
+	orderTS := httptest.NewServer(order.NewHandler(&order.Server{
+		Store:   order.NewMemStore(),
+		Catalog: order.NewCatalogClient(catalogTS.URL, stockToken(t)),
+		Log:     zap.NewNop(),
+	}, order.HTTPDeps{
+		Log:            zap.NewNop(),
+		Service:        "order",
+		AuthMode:       order.AuthModeGateway,
+		IdentitySecret: identitySecret,
+	}))
+	t.Cleanup(orderTS.Close)
+
+	h, err := gateway.NewHandler(
+		gateway.Deps{
+			JWTSecret:      jwtSecret,
+			IdentitySecret: identitySecret,
+			AuthURL:        catalogTS.URL,
+			CatalogURL:     catalogTS.URL,
+			OrderURL:       orderTS.URL,
+		},
+		gateway.HTTPDeps{Log: zap.NewNop(), Service: "gateway"},
+	)
+	if err != nil {
+		t.Fatalf("gateway.NewHandler: %v", err)
+	}
+	gwTS := httptest.NewServer(h)
+	t.Cleanup(gwTS.Close)
+
+	env := testEnv{Catalog: catalogTS, Order: orderTS, GW: gwTS, Client: &http.Client{}}
+	token := issueToken(t, "u_forwarded", "user")
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", map[string]any{
+		"items": []map[string]any{{"product_id": "p1", "qty": 1}},
+	}, map[string]string{
+		"Authorization": "Bearer " + token,
+		"X-User-ID":     "u_spoofed",
+	})
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	var created order.Order
+	if err := json.Unmarshal(raw, &created); err != nil {
+		t.Fatalf("decode order: %v body=%s", err, string(raw))
+	}
+	if created.UserID != "u_forwarded" {
+		t.Fatalf("user_id=%s want=u_forwarded", created.UserID)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.Order.URL+"/orders/"+created.ID, nil, map[string]string{
+		"Authorization": "Bearer " + token,
+		"X-User-ID":     "u_forwarded",
+		"X-User-Role":   "user",
+	})
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+}
+
+func TestGateway_PublicAPI_RoleBasedAccess(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	register(t, env, "agent@example.com", "password123")
+	agentTok := login(t, env, "agent@example.com", "password123")
+
+	resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/whoami", nil, map[string]string{
+		"Authorization": "Bearer " + agentTok,
+	})
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.Unmarshal(raw, &who); err != nil {
+		t.Fatalf("decode whoami: %v body=%s", err, string(raw))
+	}
+
+	roleURL := env.GW.URL + "/auth/admin/users/" + who.UserID + "/role"
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, roleURL, map[string]any{"role": "support"}, map[string]string{
+		"Authorization": "Bearer " + agentTok,
+	})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, roleURL, map[string]any{"role": "root"}, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, env.GW.URL+"/auth/admin/users/u_missing/role", map[string]any{"role": "support"}, admin)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, roleURL, map[string]any{"role": "support"}, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	support := login(t, env, "agent@example.com", "password123")
+	supportHdr := map[string]string{"Authorization": "Bearer " + support}
+
+	o := createOrder(t, env, issueToken(t, "u_owner", "user"), []map[string]any{{"product_id": "p1", "qty": 1}})
+
+	if got := getOrder(t, env, support, o.ID); got.ID != o.ID {
+		t.Fatalf("support read order=%s want=%s", got.ID, o.ID)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+o.ID+"/cancel", nil, supportHdr)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p9", "title": "Cable", "price_cents": 990,
+	}, supportHdr)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p9", "title": "Cable", "price_cents": 990,
+	}, map[string]string{"Authorization": "Bearer " + issueToken(t, "u_cm", "catalog_manager")})
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	resp, raw = doJSON(t, env.Client, http.MethodDelete, roleURL, nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	demoted := login(t, env, "agent@example.com", "password123")
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders/"+o.ID, nil, map[string]string{
+		"Authorization": "Bearer " + demoted,
+	})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+}
+
+type mailbox chan auth.Mail
+
+func (m mailbox) Send(_ context.Context, mail auth.Mail) error {
+	m <- mail
+	return nil
+}
+
+func (m mailbox) next(t *testing.T) auth.Mail {
+	t.Helper()
+	select {
+	case mail := <-m:
+		return mail
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no mail received")
+		return auth.Mail{}
+	}
+}
+
+func TestGateway_PublicAPI_PasswordReset(t *testing.T) {
+	t.Parallel()
+
+	box := make(mailbox, 4)
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Mailer = box
+	authSrv.ResetURL = "https://shop.example.com/reset"
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	post := func(path string, body any, want int) []byte {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+path, body, nil)
+		mustStatus(t, resp, raw, want)
+		return raw
+	}
+
+	register(t, env, "forgetful@example.com", "password123")
+	box.next(t)
+
+	var before struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	raw := post("/auth/login", map[string]any{"email": "forgetful@example.com", "password": "password123"}, http.StatusOK)
+	if err := json.Unmarshal(raw, &before); err != nil {
+		t.Fatalf("decode login: %v body=%s", err, string(raw))
+	}
+
+	unknown := post("/auth/password/forgot", map[string]any{"email": "nobody@example.com"}, http.StatusAccepted)
+	known := post("/auth/password/forgot", map[string]any{"email": " Forgetful@example.com "}, http.StatusAccepted)
+	if !bytes.Equal(unknown, known) {
+		t.Fatalf("forgot responses differ: %s vs %s", unknown, known)
+	}
+
+	mail := box.next(t)
+	if mail.To != "forgetful@example.com" {
+		t.Fatalf("mail to=%q", mail.To)
+	}
+	_, rest, ok := strings.Cut(mail.Body, authSrv.ResetURL+"?token=")
+	if !ok {
+		t.Fatalf("no reset link in body=%q", mail.Body)
+	}
+	token := strings.Fields(rest)[0]
+
+	post("/auth/password/reset", map[string]any{"token": token, "password": "short"}, http.StatusBadRequest)
+	post("/auth/password/reset", map[string]any{"token": "bogus", "password": "newpassword123"}, http.StatusBadRequest)
+	post("/auth/password/reset", map[string]any{"token": token, "password": "newpassword123"}, http.StatusNoContent)
+	post("/auth/password/reset", map[string]any{"token": token, "password": "otherpassword123"}, http.StatusBadRequest)
+
+	post("/auth/login", map[string]any{"email": "forgetful@example.com", "password": "password123"}, http.StatusUnauthorized)
+	login(t, env, "forgetful@example.com", "newpassword123")
+	post("/auth/refresh", map[string]any{"refresh_token": before.RefreshToken}, http.StatusUnauthorized)
+
+	select {
+	case extra := <-box:
+		t.Fatalf("unexpected mail to %q", extra.To)
+	default:
+	}
+}
+
+func TestGateway_PublicAPI_EmailVerification(t *testing.T) {
+	t.Parallel()
+
+	box := make(mailbox, 4)
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Mailer = box
+	authSrv.VerifyURL = "https://shop.example.com/verify"
+	env := newTestEnvWith(t, envOptions{Auth: authSrv, RequireVerifiedEmail: true})
+
+	post := func(path string, body any, headers map[string]string, want int) []byte {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+path, body, headers)
+		mustStatus(t, resp, raw, want)
+		return raw
+	}
+	bearer := func(tok string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + tok}
+	}
+	items := map[string]any{"items": []map[string]any{{"product_id": "p1", "qty": 1}}}
+
+	register(t, env, "fresh@example.com", "password123")
+	first := box.next(t)
+
+	unverified := login(t, env, "fresh@example.com", "password123")
+	post("/orders", items, bearer(unverified), http.StatusForbidden)
+
+	_, rest, ok := strings.Cut(first.Body, authSrv.VerifyURL+"?token=")
+	if !ok {
+		t.Fatalf("no verification link in body=%q", first.Body)
+	}
+	token := strings.Fields(rest)[0]
+
+	post("/orders", items, bearer(token), http.StatusUnauthorized)
+	post("/auth/verify-email", map[string]any{"token": unverified}, nil, http.StatusBadRequest)
+
+	post("/auth/verify-email/resend", map[string]any{"email": "fresh@example.com"}, nil, http.StatusAccepted)
+	box.next(t)
+
+	post("/auth/verify-email", map[string]any{"token": token}, nil, http.StatusNoContent)
+
+	verified := login(t, env, "fresh@example.com", "password123")
+	post("/orders", items, bearer(verified), http.StatusCreated)
+
+	post("/auth/verify-email/resend", map[string]any{"email": "fresh@example.com"}, nil, http.StatusAccepted)
+	select {
+	case extra := <-box:
+		t.Fatalf("unexpected mail to verified account %q", extra.To)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGateway_PublicAPI_LoginLockout(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	register(t, env, "target@example.com", "password123")
+	tok := login(t, env, "target@example.com", "password123")
+
+	resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/whoami", nil, map[string]string{
+		"Authorization": "Bearer " + tok,
+	})
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.Unmarshal(raw, &who); err != nil {
+		t.Fatalf("decode whoami: %v body=%s", err, string(raw))
+	}
+
+	// Every attempt comes from a different address, so only the per-account
+	// tracking can stop it.
+	attempt := func(i int, password string, want int) {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+			"email":    "target@example.com",
+			"password": password,
+		}, map[string]string{"X-Forwarded-For": "203.0.113." + strconv.Itoa(i)})
+		mustStatus(t, resp, raw, want)
+	}
+
+	for i := 1; i <= 3; i++ {
+		attempt(i, "wrong-password", http.StatusUnauthorized)
+	}
+
+	// While blocked the password is not checked at all: the right one gets the
+	// same answer as a wrong one or an unknown email.
+	attempt(4, "password123", http.StatusUnauthorized)
+	attempt(5, "wrong-password", http.StatusUnauthorized)
+	attempt(6, "password123", http.StatusUnauthorized)
+
+	unlockURL := env.GW.URL + "/auth/admin/users/" + who.UserID + "/unlock"
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, unlockURL, nil, map[string]string{"Authorization": "Bearer " + tok})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/admin/users/u_missing/unlock", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, unlockURL, nil, admin)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+
+	attempt(7, "password123", http.StatusOK)
+	attempt(8, "wrong-password", http.StatusUnauthorized)
+}
+
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[off:off+4])&0x7fffffff)%1000000)
+}
+
+func TestGateway_PublicAPI_TOTPLogin(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	post := func(path string, body any, headers map[string]string, want int) []byte {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+path, body, headers)
+		mustStatus(t, resp, raw, want)
+		return raw
+	}
+	creds := map[string]any{"email": "admin2fa@example.com", "password": "password123"}
+
+	register(t, env, "admin2fa@example.com", "password123")
+	bearer := map[string]string{"Authorization": "Bearer " + login(t, env, "admin2fa@example.com", "password123")}
+
+	var enroll struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	raw := post("/auth/mfa/totp/enroll", nil, bearer, http.StatusOK)
+	if err := json.Unmarshal(raw, &enroll); err != nil {
+		t.Fatalf("decode enroll: %v body=%s", err, string(raw))
+	}
+	if !strings.HasPrefix(enroll.OTPAuthURI, "otpauth://totp/") || !strings.Contains(enroll.OTPAuthURI, "secret="+enroll.Secret) {
+		t.Fatalf("otpauth_uri=%q", enroll.OTPAuthURI)
+	}
+
+	post("/auth/mfa/totp/confirm", map[string]any{"code": "000000"}, bearer, http.StatusBadRequest)
+
+	now := time.Now()
+	var rc struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	raw = post("/auth/mfa/totp/confirm", map[string]any{"code": totpAt(t, enroll.Secret, now)}, bearer, http.StatusOK)
+	if err := json.Unmarshal(raw, &rc); err != nil || len(rc.RecoveryCodes) == 0 {
+		t.Fatalf("decode recovery codes: %v body=%s", err, string(raw))
+	}
+	post("/auth/mfa/totp/enroll", nil, bearer, http.StatusConflict)
+
+	challenge := func() string {
+		t.Helper()
+		var c struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+			AccessToken string `json:"access_token"`
+		}
+		raw := post("/auth/login", creds, nil, http.StatusOK)
+		if err := json.Unmarshal(raw, &c); err != nil {
+			t.Fatalf("decode login: %v body=%s", err, string(raw))
+		}
+		if !c.MFARequired || c.MFAToken == "" || c.AccessToken != "" {
+			t.Fatalf("expected mfa challenge, body=%s", string(raw))
+		}
+		return c.MFAToken
+	}
+
+	mfaTok := challenge()
+	post("/orders", map[string]any{"items": []map[string]any{{"product_id": "p1", "qty": 1}}},
+		map[string]string{"Authorization": "Bearer " + mfaTok}, http.StatusUnauthorized)
+
+	// The confirm call consumed the current step; the next one is still inside the skew window.
+	code := totpAt(t, enroll.Secret, now.Add(30*time.Second))
+	post("/auth/login/mfa", map[string]any{"mfa_token": mfaTok, "code": "123456"}, nil, http.StatusUnauthorized)
+	raw = post("/auth/login/mfa", map[string]any{"mfa_token": mfaTok, "code": code}, nil, http.StatusOK)
+	if !strings.Contains(string(raw), "access_token") {
+		t.Fatalf("no access token: %s", string(raw))
+	}
+	post("/auth/login/mfa", map[string]any{"mfa_token": challenge(), "code": code}, nil, http.StatusUnauthorized)
+
+	recovery := rc.RecoveryCodes[0]
+	post("/auth/login/mfa", map[string]any{"mfa_token": challenge(), "code": strings.ToUpper(recovery)}, nil, http.StatusOK)
+	post("/auth/login/mfa", map[string]any{"mfa_token": challenge(), "code": recovery}, nil, http.StatusUnauthorized)
+
+	post("/auth/mfa/totp/disable", map[string]any{"code": rc.RecoveryCodes[1]}, bearer, http.StatusBadRequest)
+	post("/auth/mfa/totp/disable", map[string]any{"code": rc.RecoveryCodes[1], "password": "password123"}, bearer, http.StatusNoContent)
+	if raw := post("/auth/login", creds, map[string]string{"X-Forwarded-For": "203.0.113.9"}, http.StatusOK); !strings.Contains(string(raw), "access_token") {
+		t.Fatalf("2fa still required after disable: %s", string(raw))
+	}
+}
+
+func TestGateway_PublicAPI_ProfileManagement(t *testing.T) {
+	t.Parallel()
+
+	box := make(mailbox, 4)
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Mailer = box
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	call := func(method, path string, body any, tok string, want int) []byte {
+		t.Helper()
+		var headers map[string]string
+		if tok != "" {
+			headers = map[string]string{"Authorization": "Bearer " + tok}
+		}
+		resp, raw := doJSON(t, env.Client, method, env.GW.URL+path, body, headers)
+		mustStatus(t, resp, raw, want)
+		return raw
+	}
+	type profile struct {
+		Email         string `json:"email"`
+		DisplayName   string `json:"display_name"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	decodeProfile := func(raw []byte) profile {
+		t.Helper()
+		var p profile
+		if err := json.Unmarshal(raw, &p); err != nil {
+			t.Fatalf("decode profile: %v body=%s", err, string(raw))
+		}
+		return p
+	}
+	type tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	register(t, env, "other@example.com", "password123")
+	box.next(t)
+	register(t, env, "profile@example.com", "password123")
+	box.next(t)
+
+	var first tokens
+	raw := call(http.MethodPost, "/auth/login", map[string]any{"email": "profile@example.com", "password": "password123"}, "", http.StatusOK)
+	if err := json.Unmarshal(raw, &first); err != nil {
+		t.Fatalf("decode login: %v body=%s", err, string(raw))
+	}
+	tok := first.AccessToken
+
+	call(http.MethodGet, "/auth/me", nil, "", http.StatusUnauthorized)
+	if p := decodeProfile(call(http.MethodGet, "/auth/me", nil, tok, http.StatusOK)); p.Email != "profile@example.com" || p.DisplayName != "" {
+		t.Fatalf("profile=%+v", p)
+	}
+
+	p := decodeProfile(call(http.MethodPatch, "/auth/me", map[string]any{"display_name": "  Pat  "}, tok, http.StatusOK))
+	if p.DisplayName != "Pat" {
+		t.Fatalf("display_name=%q", p.DisplayName)
+	}
+	call(http.MethodPatch, "/auth/me", map[string]any{"display_name": strings.Repeat("x", 101)}, tok, http.StatusBadRequest)
+
+	call(http.MethodPatch, "/auth/me", map[string]any{"email": "new@example.com"}, tok, http.StatusBadRequest)
+	call(http.MethodPatch, "/auth/me", map[string]any{"email": "new@example.com", "current_password": "wrongpass1"}, tok, http.StatusForbidden)
+	call(http.MethodPatch, "/auth/me", map[string]any{"email": "other@example.com", "current_password": "password123"}, tok, http.StatusConflict)
+
+	p = decodeProfile(call(http.MethodPatch, "/auth/me", map[string]any{"email": "New@example.com", "current_password": "password123"}, tok, http.StatusOK))
+	if p.Email != "new@example.com" || p.EmailVerified || p.DisplayName != "Pat" {
+		t.Fatalf("profile after email change=%+v", p)
+	}
+	if mail := box.next(t); mail.To != "new@example.com" {
+		t.Fatalf("verification mail to=%q", mail.To)
+	}
+
+	call(http.MethodPost, "/auth/password", map[string]any{"current_password": "wrongpass1", "new_password": "newpassword123"}, tok, http.StatusForbidden)
+	call(http.MethodPost, "/auth/password", map[string]any{"current_password": "password123", "new_password": "short"}, tok, http.StatusBadRequest)
+
+	var changed tokens
+	raw = call(http.MethodPost, "/auth/password", map[string]any{"current_password": "password123", "new_password": "newpassword123"}, tok, http.StatusOK)
+	if err := json.Unmarshal(raw, &changed); err != nil || changed.RefreshToken == "" {
+		t.Fatalf("decode change password: %v body=%s", err, string(raw))
+	}
+	call(http.MethodPost, "/auth/refresh", map[string]any{"refresh_token": first.RefreshToken}, "", http.StatusUnauthorized)
+	call(http.MethodPost, "/auth/refresh", map[string]any{"refresh_token": changed.RefreshToken}, "", http.StatusOK)
+
+	call(http.MethodPost, "/auth/login", map[string]any{"email": "new@example.com", "password": "password123"}, "", http.StatusUnauthorized)
+	tok = login(t, env, "new@example.com", "newpassword123")
+
+	call(http.MethodDelete, "/auth/me", map[string]any{"password": "password123"}, tok, http.StatusForbidden)
+	call(http.MethodDelete, "/auth/me", map[string]any{"password": "newpassword123"}, tok, http.StatusNoContent)
+	call(http.MethodGet, "/auth/me", nil, tok, http.StatusUnauthorized)
+	call(http.MethodPost, "/auth/refresh", map[string]any{"refresh_token": changed.RefreshToken}, "", http.StatusUnauthorized)
+	call(http.MethodPost, "/auth/login", map[string]any{"email": "new@example.com", "password": "newpassword123"}, "", http.StatusUnauthorized)
+}
+
+func TestGateway_PublicAPI_ClientCredentials(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	call := func(method, path string, body any, headers map[string]string, want int) []byte {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, method, env.GW.URL+path, body, headers)
+		mustStatus(t, resp, raw, want)
+		return raw
+	}
+	requestToken := func(form url.Values, user, pass string, want int) string {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, env.GW.URL+"/auth/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := env.Client.Do(req)
+		if err != nil {
+			t.Fatalf("token request: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		raw, _ := io.ReadAll(resp.Body)
+		mustStatus(t, resp, raw, want)
+
+		var out struct {
+			AccessToken string `json:"access_token"`
+		}
+		_ = json.Unmarshal(raw, &out)
+		return out.AccessToken
+	}
+
+	call(http.MethodPost, "/auth/admin/service-accounts", map[string]any{"name": "nightly-export"},
+		map[string]string{"Authorization": "Bearer " + issueToken(t, "u_plain", "user")}, http.StatusForbidden)
+
+	var sa struct {
+		ID string `json:"id"`
+	}
+	raw := call(http.MethodPost, "/auth/admin/service-accounts", map[string]any{"name": "nightly-export"}, admin, http.StatusCreated)
+	if err := json.Unmarshal(raw, &sa); err != nil || sa.ID == "" {
+		t.Fatalf("decode service account: %v body=%s", err, string(raw))
+	}
+	keysPath := "/auth/admin/service-accounts/" + sa.ID + "/keys"
+
+	call(http.MethodPost, keysPath, map[string]any{"scopes": []string{"root"}}, admin, http.StatusBadRequest)
+	call(http.MethodPost, "/auth/admin/service-accounts/sa_missing/keys", map[string]any{"scopes": []string{}}, admin, http.StatusNotFound)
+
+	var key struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	raw = call(http.MethodPost, keysPath, map[string]any{
+		"scopes": []string{"products:write", "orders:read:any"},
+	}, admin, http.StatusCreated)
+	if err := json.Unmarshal(raw, &key); err != nil || !strings.HasPrefix(key.Key, key.ID+"_") || !strings.HasPrefix(key.ID, "msk_") {
+		t.Fatalf("decode api key: %v body=%s", err, string(raw))
+	}
+
+	grant := url.Values{"grant_type": {"client_credentials"}}
+	requestToken(url.Values{"grant_type": {"password"}}, sa.ID, key.Key, http.StatusBadRequest)
+	requestToken(grant, sa.ID, key.ID+"_wrong", http.StatusUnauthorized)
+	requestToken(grant, "sa_other", key.Key, http.StatusUnauthorized)
+	requestToken(url.Values{"grant_type": {"client_credentials"}, "scope": {"users:write"}}, sa.ID, key.Key, http.StatusBadRequest)
+
+	full := requestToken(grant, sa.ID, key.Key, http.StatusOK)
+	fullHdr := map[string]string{"Authorization": "Bearer " + full}
+
+	o := createOrder(t, env, issueToken(t, "u_owner", "user"), []map[string]any{{"product_id": "p1", "qty": 1}})
+	if got := getOrder(t, env, full, o.ID); got.ID != o.ID {
+		t.Fatalf("service read order=%s want=%s", got.ID, o.ID)
+	}
+	call(http.MethodPost, "/products", map[string]any{"id": "p9", "title": "Cable", "price_cents": 990}, fullHdr, http.StatusCreated)
+	call(http.MethodPost, "/orders", map[string]any{"items": []map[string]any{{"product_id": "p1", "qty": 1}}}, fullHdr, http.StatusCreated)
+	call(http.MethodPost, "/orders/"+o.ID+"/cancel", nil, fullHdr, http.StatusForbidden)
+	call(http.MethodPut, "/auth/admin/users/u_owner/role", map[string]any{"role": "admin"}, fullHdr, http.StatusForbidden)
+
+	narrow := requestToken(url.Values{
+		"grant_type":    {"client_credentials"},
+		"scope":         {"products:write"},
+		"client_id":     {sa.ID},
+		"client_secret": {key.Key},
+	}, "", "", http.StatusOK)
+	call(http.MethodGet, "/orders/"+o.ID, nil, map[string]string{"Authorization": "Bearer " + narrow}, http.StatusForbidden)
+
+	var keys struct {
+		Items []struct {
+			ID         string     `json:"id"`
+			LastUsedAt *time.Time `json:"last_used_at"`
+		} `json:"items"`
+	}
+	raw = call(http.MethodGet, keysPath, nil, admin, http.StatusOK)
+	if err := json.Unmarshal(raw, &keys); err != nil || len(keys.Items) != 1 || keys.Items[0].LastUsedAt == nil {
+		t.Fatalf("list keys: %v body=%s", err, string(raw))
+	}
+	if strings.Contains(string(raw), key.Key) {
+		t.Fatalf("key secret leaked in listing: %s", string(raw))
+	}
+
+	call(http.MethodDelete, keysPath+"/"+key.ID, nil, admin, http.StatusNoContent)
+	requestToken(grant, sa.ID, key.Key, http.StatusUnauthorized)
+}
+
+func TestGateway_PublicAPI_TokenRevocation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := "Bearer " + issueToken(t, "u_admin", "admin")
+	bearer := func(tok string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + tok}
+	}
+	introspect := func(caller, tok string, want int) map[string]any {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, env.GW.URL+"/auth/introspect", strings.NewReader(url.Values{"token": {tok}}.Encode()))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", caller)
+		resp, err := env.Client.Do(req)
+		if err != nil {
+			t.Fatalf("introspect: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		raw, _ := io.ReadAll(resp.Body)
+		mustStatus(t, resp, raw, want)
+
+		out := map[string]any{}
+		_ = json.Unmarshal(raw, &out)
+		return out
+	}
+	revoke := func(body map[string]any, want int) {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/admin/revocations", body, map[string]string{"Authorization": admin})
+		mustStatus(t, resp, raw, want)
+	}
+	ordersStatus := func(tok string) int {
+		t.Helper()
+		resp, _ := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders", nil, bearer(tok))
+		return resp.StatusCode
+	}
+	waitRejected := func(tok string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for ordersStatus(tok) != http.StatusUnauthorized {
+			if time.Now().After(deadline) {
+				t.Fatalf("revoked token still accepted by gateway")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	register(t, env, "revoked@example.com", "password123")
+	first := login(t, env, "revoked@example.com", "password123")
+	if got := ordersStatus(first); got != http.StatusOK {
+		t.Fatalf("orders status=%d want=200", got)
+	}
+
+	introspect("Bearer "+first, first, http.StatusForbidden)
+	info := introspect(admin, first, http.StatusOK)
+	if info["active"] != true || info["jti"] == "" || info["username"] != "revoked@example.com" {
+		t.Fatalf("introspect=%v", info)
+	}
+	userID, _ := info["sub"].(string)
+	if got := introspect(admin, "not-a-token", http.StatusOK); got["active"] != false {
+		t.Fatalf("introspect garbage=%v", got)
+	}
+
+	resp, raw := doJSON(t, env.Client, http.MethodGet, env.Auth.URL+"/internal/revocations", nil, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.Auth.URL+"/internal/revocations", nil, bearer(first))
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	revoke(map[string]any{}, http.StatusBadRequest)
+	revoke(map[string]any{"token": first}, http.StatusNoContent)
+	waitRejected(first)
+	if got := introspect(admin, first, http.StatusOK); got["active"] != false {
+		t.Fatalf("introspect revoked=%v", got)
+	}
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/whoami", nil, bearer(first))
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+
+	second := login(t, env, "revoked@example.com", "password123")
+	if got := ordersStatus(second); got != http.StatusOK {
+		t.Fatalf("orders status=%d want=200", got)
+	}
+
+	// User cutoffs have iat precision; step into the next second so the
+	// cutoff lands after the token was issued.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	revoke(map[string]any{"user_id": userID}, http.StatusNoContent)
+	waitRejected(second)
+
+	third := login(t, env, "revoked@example.com", "password123")
+	if got := ordersStatus(third); got != http.StatusOK {
+		t.Fatalf("orders status after re-login=%d want=200", got)
+	}
+}
+
+func TestGateway_PublicAPI_PasswordHashUpgrade(t *testing.T) {
+	t.Parallel()
+
+	store := auth.NewMemStore().WithHasher(auth.BcryptHasher{Cost: bcrypt.MinCost})
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Store = store
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	storedHash := func(email string) string {
+		t.Helper()
+		u, err := store.GetByEmail(context.Background(), email)
+		if err != nil {
+			t.Fatalf("GetByEmail: %v", err)
+		}
+		return string(u.Hash)
+	}
+	loginStatus := func(email, password string) int {
+		t.Helper()
+		resp, _ := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+			"email": email, "password": password,
+		}, nil)
+		return resp.StatusCode
+	}
+
+	register(t, env, "legacy@example.com", "password123")
+	if h := storedHash("legacy@example.com"); !strings.HasPrefix(h, "$2a$") {
+		t.Fatalf("hash=%q want bcrypt", h)
+	}
+
+	store.WithHasher(auth.NewArgon2idHasher())
+
+	if got := loginStatus("legacy@example.com", "wrongpass1"); got != http.StatusUnauthorized {
+		t.Fatalf("wrong password status=%d", got)
+	}
+	if h := storedHash("legacy@example.com"); !strings.HasPrefix(h, "$2a$") {
+		t.Fatalf("hash upgraded on failed login: %q", h)
+	}
+
+	login(t, env, "legacy@example.com", "password123")
+	if h := storedHash("legacy@example.com"); !strings.HasPrefix(h, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("hash=%q want argon2id", h)
+	}
+
+	// Changed argon2id parameters are picked up the same way.
+	store.WithHasher(&auth.Argon2idHasher{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	login(t, env, "legacy@example.com", "password123")
+	if h := storedHash("legacy@example.com"); !strings.HasPrefix(h, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash=%q want new parameters", h)
+	}
+	store.WithHasher(auth.NewArgon2idHasher())
+
+	long := strings.Repeat("a", 72) + "tail-that-bcrypt-would-drop"
+	register(t, env, "long@example.com", long)
+	if got := loginStatus("long@example.com", long[:72]); got != http.StatusUnauthorized {
+		t.Fatalf("truncated password status=%d want=401", got)
+	}
+	login(t, env, "long@example.com", long)
+}
+
+// rewriteHasher stores whatever rewrite makes of a real hash, to plant
+// malformed hashes in the store.
+type rewriteHasher struct {
+	auth.PasswordHasher
+	rewrite func(string) string
+}
+
+func (h rewriteHasher) Hash(password string) ([]byte, error) {
+	b, err := h.PasswordHasher.Hash(password)
+	return []byte(h.rewrite(string(b))), err
+}
+
+func TestGateway_PublicAPI_MalformedPasswordHashRejected(t *testing.T) {
+	t.Parallel()
+
+	store := auth.NewMemStore()
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Store = store
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	cheap := func(saltLen uint32) *auth.Argon2idHasher {
+		return &auth.Argon2idHasher{Memory: 64, Time: 1, Threads: 1, SaltLen: saltLen, KeyLen: 32}
+	}
+	keep := func(h string) string { return h }
+
+	tests := []struct {
+		name    string
+		hasher  auth.PasswordHasher
+		rewrite func(string) string
+		want    int
+	}{
+		{"valid", cheap(16), keep, http.StatusOK},
+		{"no threads", cheap(16), func(h string) string { return strings.Replace(h, ",p=1$", ",p=0$", 1) }, http.StatusUnauthorized},
+		{"no passes", cheap(16), func(h string) string { return strings.Replace(h, ",t=1,", ",t=0,", 1) }, http.StatusUnauthorized},
+		{"trailing params", cheap(16), func(h string) string { return strings.Replace(h, ",p=1$", ",p=1x$", 1) }, http.StatusUnauthorized},
+		{"trailing version", cheap(16), func(h string) string { return strings.Replace(h, "$v=19$", "$v=19x$", 1) }, http.StatusUnauthorized},
+		{"empty salt", cheap(0), keep, http.StatusUnauthorized},
+		{"short salt", cheap(4), keep, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		email := "hash" + strconv.Itoa(i) + "@example.com"
+		store.WithHasher(rewriteHasher{PasswordHasher: tt.hasher, rewrite: tt.rewrite})
+		if err := store.Create(context.Background(), email, "password123", "user", "u_hash"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("%s: create: %v", tt.name, err)
+		}
+
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+			"email": email, "password": "password123",
+		}, map[string]string{"X-Forwarded-For": "192.0.2." + strconv.Itoa(i+1)})
+		if resp.StatusCode != tt.want {
+			t.Fatalf("%s: status=%d want=%d body=%s", tt.name, resp.StatusCode, tt.want, string(raw))
+		}
+	}
+}
+
+func TestGateway_PublicAPI_AuditLog(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+
+	register(t, env, "audited@example.com", "password123")
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+		"email":    "audited@example.com",
+		"password": "wrong-password",
+	}, map[string]string{"User-Agent": "audit-test/1.0"})
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	tok := login(t, env, "audited@example.com", "password123")
+
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/whoami", nil, map[string]string{"Authorization": "Bearer " + tok})
+	mustStatus(t, resp, raw, http.StatusOK)
+	if err := json.Unmarshal(raw, &who); err != nil || who.UserID == "" {
+		t.Fatalf("decode whoami: %v body=%s", err, string(raw))
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPut, env.GW.URL+"/auth/admin/users/"+who.UserID+"/role", map[string]any{"role": "support"}, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	type event struct {
+		ID        int64             `json:"id"`
+		Type      string            `json:"type"`
+		UserID    string            `json:"user_id"`
+		ActorID   string            `json:"actor_id"`
+		IP        string            `json:"ip"`
+		UserAgent string            `json:"user_agent"`
+		RequestID string            `json:"request_id"`
+		Details   map[string]string `json:"details"`
+	}
+	list := func(query string, headers map[string]string, want int) ([]event, string) {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/audit"+query, nil, headers)
+		mustStatus(t, resp, raw, want)
+		var out struct {
+			Items      []event `json:"items"`
+			NextCursor string  `json:"next_cursor"`
+		}
+		_ = json.Unmarshal(raw, &out)
+		return out.Items, out.NextCursor
+	}
+
+	list("", map[string]string{"Authorization": "Bearer " + tok}, http.StatusForbidden)
+	list("?limit=0", admin, http.StatusBadRequest)
+	list("?since=yesterday", admin, http.StatusBadRequest)
+
+	events, _ := list("?user_id="+who.UserID, admin, http.StatusOK)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []string{"role.changed", "token.issued", "login.succeeded", "login.failed", "user.registered"}
+	if !slices.Equal(types, want) {
+		t.Fatalf("types=%v want=%v", types, want)
+	}
+	for _, e := range events {
+		if e.IP == "" || e.RequestID == "" {
+			t.Fatalf("event missing client details: %+v", e)
+		}
+	}
+	if got := events[0]; got.ActorID != "u_admin" || got.Details["role"] != "support" {
+		t.Fatalf("role change event=%+v", got)
+	}
+	if got := events[3]; got.UserAgent != "audit-test/1.0" || got.Details["reason"] != "invalid_credentials" {
+		t.Fatalf("login failure event=%+v", got)
+	}
+
+	page, next := list("?user_id="+who.UserID+"&limit=2", admin, http.StatusOK)
+	if len(page) != 2 || next == "" || page[0].ID != events[0].ID {
+		t.Fatalf("first page=%+v next=%q", page, next)
+	}
+	page, next = list("?user_id="+who.UserID+"&limit=2&cursor="+next, admin, http.StatusOK)
+	if len(page) != 2 || next == "" || page[0].ID != events[2].ID {
+		t.Fatalf("second page=%+v next=%q", page, next)
+	}
+	page, next = list("?user_id="+who.UserID+"&limit=2&cursor="+next, admin, http.StatusOK)
+	if len(page) != 1 || next != "" || page[0].Type != "user.registered" {
+		t.Fatalf("last page=%+v next=%q", page, next)
+	}
+
+	failed, _ := list("?type=login.failed", admin, http.StatusOK)
+	if len(failed) != 1 || failed[0].UserID != who.UserID {
+		t.Fatalf("login.failed events=%+v", failed)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if got, _ := list("?since="+future, admin, http.StatusOK); len(got) != 0 {
+		t.Fatalf("since future=%+v", got)
+	}
+}
+
+func TestGateway_PublicAPI_Sessions(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	type pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	loginFrom := func(ua string) pair {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+			"email":    "devices@example.com",
+			"password": "password123",
+		}, map[string]string{"User-Agent": ua})
+		mustStatus(t, resp, raw, http.StatusOK)
+		var p pair
+		if err := json.Unmarshal(raw, &p); err != nil {
+			t.Fatalf("decode login: %v body=%s", err, string(raw))
+		}
+		return p
+	}
+	bearer := func(tok string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + tok}
+	}
+	type session struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		IP         string    `json:"ip"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		Current    bool      `json:"current"`
+	}
+	sessions := func(tok string) []session {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/sessions", nil, bearer(tok))
+		mustStatus(t, resp, raw, http.StatusOK)
+		var out struct {
+			Items []session `json:"items"`
+		}
+		if err := json.Unmarshal(raw, &out); err != nil {
+			t.Fatalf("decode sessions: %v body=%s", err, string(raw))
+		}
+		return out.Items
+	}
+
+	register(t, env, "devices@example.com", "password123")
+	laptop := loginFrom("laptop-browser")
+	phone := loginFrom("phone-app")
+
+	list := sessions(laptop.AccessToken)
+	if len(list) != 2 {
+		t.Fatalf("sessions=%+v want 2", list)
+	}
+	var laptopID, phoneID string
+	for _, s := range list {
+		if s.IP == "" || s.LastSeenAt.IsZero() {
+			t.Fatalf("session missing details: %+v", s)
+		}
+		switch s.UserAgent {
+		case "laptop-browser":
+			laptopID = s.ID
+			if !s.Current {
+				t.Fatalf("laptop session not marked current: %+v", s)
+			}
+		case "phone-app":
+			phoneID = s.ID
+			if s.Current {
+				t.Fatalf("phone session marked current: %+v", s)
+			}
+		}
+	}
+	if laptopID == "" || phoneID == "" {
+		t.Fatalf("sessions=%+v", list)
+	}
+
+	other := issueToken(t, "u_other", "user")
+	resp, raw := doJSON(t, env.Client, http.MethodDelete, env.GW.URL+"/auth/sessions/"+phoneID, nil, bearer(other))
+	mustStatus(t, resp, raw, http.StatusNotFound)
+	resp, raw = doJSON(t, env.Client, http.MethodDelete, env.GW.URL+"/auth/sessions/ses_missing", nil, bearer(laptop.AccessToken))
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	resp, raw = doJSON(t, env.Client, http.MethodDelete, env.GW.URL+"/auth/sessions/"+phoneID, nil, bearer(laptop.AccessToken))
+	mustStatus(t, resp, raw, http.StatusNoContent)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, _ := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders", nil, bearer(phone.AccessToken))
+		if resp.StatusCode == http.StatusUnauthorized {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("terminated session token still accepted by gateway")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/refresh", map[string]any{"refresh_token": phone.RefreshToken}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+
+	resp, _ = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/orders", nil, bearer(laptop.AccessToken))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("current session rejected: status=%d", resp.StatusCode)
+	}
+	if list := sessions(laptop.AccessToken); len(list) != 1 || list[0].ID != laptopID {
+		t.Fatalf("sessions after sign-out=%+v", list)
+	}
+
+	// Refreshing keeps the session and carries its id in the new token.
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/refresh", map[string]any{"refresh_token": laptop.RefreshToken}, map[string]string{"User-Agent": "laptop-browser/2"})
+	mustStatus(t, resp, raw, http.StatusOK)
+	var refreshed pair
+	if err := json.Unmarshal(raw, &refreshed); err != nil {
+		t.Fatalf("decode refresh: %v", err)
+	}
+	list = sessions(refreshed.AccessToken)
+	if len(list) != 1 || list[0].ID != laptopID || !list[0].Current || list[0].UserAgent != "laptop-browser/2" {
+		t.Fatalf("sessions after refresh=%+v", list)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/logout", map[string]any{"refresh_token": refreshed.RefreshToken}, nil)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/sessions", nil, bearer(refreshed.AccessToken))
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+}
+
+func TestGateway_PublicAPI_OIDCAuthorizationCode(t *testing.T) {
+	t.Parallel()
+
+	const issuer = "https://shop.example.com"
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Issuer = issuer + "/"
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	const callback = "https://app.example.com/callback"
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/admin/clients", map[string]any{
+		"name":          "Storefront SPA",
+		"redirect_uris": []string{"ftp://nope"},
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/admin/clients", map[string]any{
+		"name":          "Storefront SPA",
+		"redirect_uris": []string{callback},
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+	var client struct {
+		ID     string `json:"id"`
+		Secret string `json:"client_secret"`
+	}
+	if err := json.Unmarshal(raw, &client); err != nil || client.ID == "" || client.Secret != "" {
+		t.Fatalf("create client: %v body=%s", err, string(raw))
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/.well-known/openid-configuration", nil, nil)
+	mustStatus(t, resp, raw, http.StatusOK)
+	var disco map[string]any
+	if err := json.Unmarshal(raw, &disco); err != nil {
+		t.Fatalf("decode discovery: %v", err)
+	}
+	if disco["issuer"] != issuer || disco["authorization_endpoint"] != issuer+"/auth/authorize" ||
+		disco["token_endpoint"] != issuer+"/auth/token" || disco["userinfo_endpoint"] != issuer+"/auth/userinfo" {
+		t.Fatalf("discovery=%v", disco)
+	}
+
+	register(t, env, "oidc@example.com", "password123")
+	resp, raw = doJSON(t, env.Client, http.MethodPatch, env.GW.URL+"/auth/me", map[string]any{"display_name": "Olive Dee"},
+		map[string]string{"Authorization": "Bearer " + login(t, env, "oidc@example.com", "password123")})
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	verifier := strings.Repeat("v", 20) + "-pkce-verifier-for-the-test-flow"
+	sum := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {callback},
+		"scope":                 {"openid email profile"},
+		"state":                 {"st-123"},
+		"nonce":                 {"n-456"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	with := func(k, v string) url.Values {
+		out := url.Values{}
+		for key, vals := range params {
+			out[key] = vals
+		}
+		out.Set(k, v)
+		return out
+	}
+	get := func(q url.Values) (*http.Response, string) {
+		t.Helper()
+		resp, err := browser.Get(env.GW.URL + "/auth/authorize?" + q.Encode())
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	// The login form is only accepted together with the CSRF cookie set
+	// when it was rendered.
+	var csrfCookie *http.Cookie
+	var csrfToken string
+	postWith := func(form url.Values, cookie *http.Cookie) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, env.GW.URL+"/auth/authorize", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "oidc-browser")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := browser.Do(req)
+		if err != nil {
+			t.Fatalf("authorize post: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	post := func(form url.Values) (*http.Response, string) {
+		t.Helper()
+		form.Set("csrf_token", csrfToken)
+		return postWith(form, csrfCookie)
+	}
+	redirected := func(resp *http.Response) url.Values {
+		t.Helper()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("status=%d want=302", resp.StatusCode)
+		}
+		loc, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || !strings.HasPrefix(loc.String(), callback+"?") {
+			t.Fatalf("location=%q", resp.Header.Get("Location"))
+		}
+		return loc.Query()
+	}
+	authorize := func() string {
+		t.Helper()
+		form := with("email", "oidc@example.com")
+		form.Set("password", "password123")
+		resp, body := post(form)
+		q := redirected(resp)
+		if q.Get("code") == "" || q.Get("state") != "st-123" {
+			t.Fatalf("callback query=%v body=%s", q, body)
+		}
+		return q.Get("code")
+	}
+	exchange := func(code, verifier string, want int) map[string]any {
+		t.Helper()
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {client.ID},
+			"code":          {code},
+			"redirect_uri":  {callback},
+			"code_verifier": {verifier},
+		}
+		resp, err := env.Client.PostForm(env.GW.URL+"/auth/token", form)
+		if err != nil {
+			t.Fatalf("token: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		raw, _ := io.ReadAll(resp.Body)
+		mustStatus(t, resp, raw, want)
+		out := map[string]any{}
+		_ = json.Unmarshal(raw, &out)
+		return out
+	}
+
+	if resp, body := get(with("redirect_uri", "https://evil.example.com/cb")); resp.StatusCode != http.StatusBadRequest ||
+		!strings.Contains(body, "not registered") {
+		t.Fatalf("unregistered redirect: status=%d body=%s", resp.StatusCode, body)
+	}
+	resp, _ = get(with("code_challenge_method", "plain"))
+	if q := redirected(resp); q.Get("error") != "invalid_request" || q.Get("state") != "st-123" {
+		t.Fatalf("plain pkce error=%v", q)
+	}
+	resp, body := get(params)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Storefront SPA") || !strings.Contains(body, `name="password"`) {
+		t.Fatalf("login page: status=%d body=%s", resp.StatusCode, body)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == "ministore_authorize_csrf" {
+			csrfCookie = c
+		}
+	}
+	if _, rest, ok := strings.Cut(body, `name="csrf_token" value="`); ok {
+		csrfToken, _, _ = strings.Cut(rest, `"`)
+	}
+	if csrfCookie == nil || !csrfCookie.HttpOnly || !csrfCookie.Secure || csrfCookie.SameSite != http.SameSiteStrictMode ||
+		csrfToken != csrfCookie.Value {
+		t.Fatalf("csrf cookie=%+v token=%q", csrfCookie, csrfToken)
+	}
+
+	forged := with("email", "oidc@example.com")
+	forged.Set("password", "password123")
+	forged.Set("csrf_token", csrfToken)
+	if resp, body := postWith(forged, nil); resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "form expired") {
+		t.Fatalf("post without csrf cookie: status=%d body=%s", resp.StatusCode, body)
+	}
+
+	bad := with("email", "oidc@example.com")
+	bad.Set("password", "wrong-password")
+	if resp, body := post(bad); resp.StatusCode != http.StatusUnauthorized || !strings.Contains(body, "Invalid email or password") {
+		t.Fatalf("wrong password: status=%d body=%s", resp.StatusCode, body)
+	}
+
+	code := authorize()
+	exchange(code, strings.Repeat("x", 43), http.StatusBadRequest)
+	exchange(code, verifier, http.StatusBadRequest)
+
+	code = authorize()
+	tokens := exchange(code, verifier, http.StatusOK)
+	exchange(code, verifier, http.StatusBadRequest)
+
+	access, _ := tokens["access_token"].(string)
+	idTok, _ := tokens["id_token"].(string)
+	refresh, _ := tokens["refresh_token"].(string)
+	if access == "" || idTok == "" || refresh == "" || tokens["scope"] != "openid email profile" {
+		t.Fatalf("tokens=%v", tokens)
+	}
+
+	var id auth.IDClaims
+	if _, err := jwt.ParseWithClaims(idTok, &id, func(*jwt.Token) (any, error) { return []byte(jwtSecret), nil },
+		jwt.WithIssuer(issuer), jwt.WithAudience(client.ID)); err != nil {
+		t.Fatalf("parse id token: %v", err)
+	}
+	if id.Nonce != "n-456" || id.Email != "oidc@example.com" || id.Name != "Olive Dee" || id.SessionID == "" || id.AuthTime == nil {
+		t.Fatalf("id token claims=%+v", id)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/userinfo", nil, map[string]string{"Authorization": "Bearer " + access})
+	mustStatus(t, resp, raw, http.StatusOK)
+	var info map[string]any
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatalf("decode userinfo: %v", err)
+	}
+	if info["sub"] != id.Subject || info["email"] != "oidc@example.com" || info["name"] != "Olive Dee" {
+		t.Fatalf("userinfo=%v", info)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/sessions", nil, map[string]string{"Authorization": "Bearer " + access})
+	mustStatus(t, resp, raw, http.StatusOK)
+	if !strings.Contains(string(raw), `"user_agent":"oidc-browser"`) {
+		t.Fatalf("sessions=%s", string(raw))
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/admin/clients", map[string]any{
+		"name":          "Other App",
+		"redirect_uris": []string{"https://other.example.com/callback"},
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+	var other struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &other); err != nil || other.ID == "" {
+		t.Fatalf("create other client: %v body=%s", err, string(raw))
+	}
+
+	refreshGrant := func(clientID string, want int) {
+		t.Helper()
+		resp, err := env.Client.PostForm(env.GW.URL+"/auth/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {clientID},
+			"refresh_token": {refresh},
+		})
+		if err != nil {
+			t.Fatalf("refresh grant: %v", err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		mustStatus(t, resp, raw, want)
+		if want == http.StatusBadRequest && !strings.Contains(string(raw), "invalid grant") {
+			t.Fatalf("refresh grant body=%s", string(raw))
+		}
+	}
+
+	// A refresh token only works for the client it was issued to; failed
+	// attempts by anyone else leave it usable.
+	refreshGrant(other.ID, http.StatusBadRequest)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/refresh", map[string]any{"refresh_token": refresh}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	refreshGrant(client.ID, http.StatusOK)
+}
+
+func TestGateway_PublicAPI_AdminUserManagement(t *testing.T) {
+	t.Parallel()
+
+	box := make(mailbox, 8)
+	authSrv := newAuthServer(jwtSecret)
+	authSrv.Mailer = box
+	authSrv.ResetURL = "https://shop.example.com/reset"
+	env := newTestEnvWith(t, envOptions{Auth: authSrv})
+
+	for _, email := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		register(t, env, email, "password123")
+		box.next(t)
+	}
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	usersURL := env.GW.URL + "/auth/admin/users"
+
+	type adminUser struct {
+		ID         string `json:"id"`
+		Email      string `json:"email"`
+		Disabled   bool   `json:"disabled"`
+		MFAEnabled *bool  `json:"mfa_enabled"`
+	}
+	type page struct {
+		Items      []adminUser `json:"items"`
+		NextCursor string      `json:"next_cursor"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, usersURL+query, nil, admin)
+		mustStatus(t, resp, raw, http.StatusOK)
+		var p page
+		if err := json.Unmarshal(raw, &p); err != nil {
+			t.Fatalf("decode users: %v body=%s", err, string(raw))
+		}
+		return p
+	}
+
+	// Every login comes from its own address so the IP limiter stays out of
+	// the way.
+	var logins int
+	loginAs := func(email, password string, want int) map[string]any {
+		t.Helper()
+		logins++
+		resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+			"email": email, "password": password,
+		}, map[string]string{"X-Forwarded-For": "198.51.100." + strconv.Itoa(logins)})
+		mustStatus(t, resp, raw, want)
+		var out map[string]any
+		_ = json.Unmarshal(raw, &out)
+		return out
+	}
+
+	user := loginAs("carol@example.com", "password123", http.StatusOK)["access_token"].(string)
+	resp, raw := doJSON(t, env.Client, http.MethodGet, usersURL, nil, map[string]string{"Authorization": "Bearer " + user})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	if p := list("?email=ALI"); len(p.Items) != 1 || p.Items[0].Email != "alice@example.com" {
+		t.Fatalf("email filter=%+v", p)
+	}
+	if p := list("?role=admin"); len(p.Items) != 0 {
+		t.Fatalf("role filter=%+v", p)
+	}
+
+	first := list("?limit=2")
+	if len(first.Items) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page=%+v", first)
+	}
+	second := list("?limit=2&cursor=" + first.NextCursor)
+	if len(second.Items) != 1 || second.NextCursor != "" || second.Items[0].ID == first.Items[1].ID {
+		t.Fatalf("second page=%+v", second)
+	}
+
+	// Newest first, one user per page.
+	var emails []string
+	for q, i := "?limit=1", 0; ; i++ {
+		if i > 3 {
+			t.Fatalf("pagination did not terminate")
+		}
+		p := list(q)
+		for _, u := range p.Items {
+			emails = append(emails, u.Email)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		q = "?limit=1&cursor=" + p.NextCursor
+	}
+	if got := strings.Join(emails, ","); got != "carol@example.com,bob@example.com,alice@example.com" {
+		t.Fatalf("emails=%s", got)
+	}
+
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, c := range []string{
+		"bogus",
+		enc("u_1"),
+		enc(`{"c":"2026-01-01T00:00:00Z"}`),
+		enc(`{"c":"yesterday","id":"u_1"}`),
+	} {
+		resp, raw = doJSON(t, env.Client, http.MethodGet, usersURL+"?cursor="+c, nil, admin)
+		mustStatus(t, resp, raw, http.StatusBadRequest)
+	}
+
+	alice := list("?email=alice@example.com").Items[0]
+	bob := list("?email=bob@example.com").Items[0]
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, usersURL+"/"+alice.ID, nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+	var got adminUser
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if got.Email != "alice@example.com" || got.Disabled || got.MFAEnabled == nil || *got.MFAEnabled {
+		t.Fatalf("user=%+v", got)
+	}
+	resp, raw = doJSON(t, env.Client, http.MethodGet, usersURL+"/u_missing", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	tokens := loginAs("alice@example.com", "password123", http.StatusOK)
+	aliceAuth := map[string]string{"Authorization": "Bearer " + tokens["access_token"].(string)}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, usersURL+"/u_admin/disable", nil, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, usersURL+"/"+alice.ID+"/disable", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/whoami", nil, aliceAuth)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/refresh", map[string]any{
+		"refresh_token": tokens["refresh_token"],
+	}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+
+	loginAs("alice@example.com", "wrong-password", http.StatusUnauthorized)
+	loginAs("alice@example.com", "password123", http.StatusForbidden)
+	if p := list("?disabled=true"); len(p.Items) != 1 || p.Items[0].ID != alice.ID {
+		t.Fatalf("disabled filter=%+v", p)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, usersURL+"/"+alice.ID+"/enable", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+	loginAs("alice@example.com", "password123", http.StatusOK)
+
+	bobTokens := loginAs("bob@example.com", "password123", http.StatusOK)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, usersURL+"/"+bob.ID+"/password-reset", nil, admin)
+	mustStatus(t, resp, raw, http.StatusAccepted)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/refresh", map[string]any{
+		"refresh_token": bobTokens["refresh_token"],
+	}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	loginAs("bob@example.com", "password123", http.StatusUnauthorized)
+
+	mail := box.next(t)
+	_, rest, ok := strings.Cut(mail.Body, authSrv.ResetURL+"?token=")
+	if mail.To != "bob@example.com" || !ok {
+		t.Fatalf("reset mail to=%q body=%q", mail.To, mail.Body)
+	}
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/password/reset", map[string]any{
+		"token": strings.Fields(rest)[0], "password": "newpassword123",
+	}, nil)
+	mustStatus(t, resp, raw, http.StatusNoContent)
+	loginAs("bob@example.com", "newpassword123", http.StatusOK)
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/auth/audit?user_id="+alice.ID+"&type=user.disabled", nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+	if !strings.Contains(string(raw), `"actor_id":"u_admin"`) {
+		t.Fatalf("audit=%s", string(raw))
+	}
+}
+
+func TestGateway_PublicAPI_PersonalDataExportAndErasure(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	register(t, env, "subject@example.com", "password123")
+	tok := login(t, env, "subject@example.com", "password123")
+	created := createOrder(t, env, tok, []map[string]any{{"product_id": "p1", "qty": 2}})
+	userID := created.UserID
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	exportURL := env.GW.URL + "/admin/users/" + userID + "/export"
+	eraseURL := env.GW.URL + "/admin/users/" + userID + "/erase"
+
+	resp, raw := doJSON(t, env.Client, http.MethodGet, exportURL, nil, map[string]string{"Authorization": "Bearer " + tok})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.Order.URL+"/internal/users/"+userID+"/orders", nil,
+		map[string]string{"Authorization": "Bearer " + tok})
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	type archive struct {
+		UserID string `json:"user_id"`
+		Auth   *struct {
+			User struct {
+				Email string `json:"email"`
+			} `json:"user"`
+			Sessions    []map[string]any `json:"sessions"`
+			AuditEvents []map[string]any `json:"audit_events"`
+		} `json:"auth"`
+		Orders []order.Order `json:"orders"`
+	}
+	export := func() archive {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, exportURL, nil, admin)
+		mustStatus(t, resp, raw, http.StatusOK)
+		if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+			t.Fatalf("Content-Disposition=%q", cd)
+		}
+		var a archive
+		if err := json.Unmarshal(raw, &a); err != nil {
+			t.Fatalf("decode archive: %v body=%s", err, string(raw))
+		}
+		return a
+	}
+
+	before := export()
+	if before.UserID != userID || before.Auth == nil || before.Auth.User.Email != "subject@example.com" {
+		t.Fatalf("archive=%+v", before)
+	}
+	if len(before.Auth.Sessions) != 1 || len(before.Auth.AuditEvents) == 0 || before.Auth.AuditEvents[0]["ip"] == nil {
+		t.Fatalf("sessions=%v audit=%v", before.Auth.Sessions, before.Auth.AuditEvents)
+	}
+	if len(before.Orders) != 1 || before.Orders[0].ID != created.ID || len(before.Orders[0].Items) != 1 {
+		t.Fatalf("orders=%+v", before.Orders)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/admin/users/u_missing/export", nil, admin)
+	mustStatus(t, resp, raw, http.StatusNotFound)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, eraseURL, nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+	var erased struct {
+		Account        string `json:"account"`
+		Orders         int    `json:"orders"`
+		OrderPseudonym string `json:"order_pseudonym"`
+	}
+	if err := json.Unmarshal(raw, &erased); err != nil {
+		t.Fatalf("decode erase: %v", err)
+	}
+	if erased.Account != "anonymized" || erased.Orders != 1 || erased.OrderPseudonym == "" {
+		t.Fatalf("erase=%+v", erased)
+	}
+
+	after := export()
+	if after.Auth == nil || after.Auth.User.Email != userID+"@erased.invalid" || len(after.Auth.Sessions) != 0 || len(after.Orders) != 0 {
+		t.Fatalf("archive after erase=%+v", after)
+	}
+	// The user's own audit events stay but lose their IP and user agent;
+	// the admin's requests about them are kept as they were.
+	var own, byAdmin int
+	for _, e := range after.Auth.AuditEvents {
+		if e["actor_id"] == "u_admin" {
+			byAdmin++
+			continue
+		}
+		own++
+		if e["ip"] != nil || e["user_agent"] != nil {
+			t.Fatalf("audit event after erase=%v", e)
+		}
+	}
+	if own < len(before.Auth.AuditEvents)-1 || byAdmin == 0 {
+		t.Fatalf("audit after erase=%v", after.Auth.AuditEvents)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/auth/login", map[string]any{
+		"email": "subject@example.com", "password": "password123",
+	}, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+
+	kept := getOrder(t, env, issueToken(t, "u_support", "support"), created.ID)
+	if kept.UserID != erased.OrderPseudonym || kept.TotalCents != created.TotalCents {
+		t.Fatalf("order after erase=%+v", kept)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, eraseURL, nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+}
+
+func TestGateway_PublicAPI_StockTracking(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+	user := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_user", "user")}
+	buyer := issueToken(t, "u_buyer", "user")
+
+	resp, raw := doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/products", map[string]any{
+		"id": "p3", "title": "Monitor", "price_cents": 19990,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	getProduct := func() catalog.Product {
+		t.Helper()
+		resp, raw := doJSON(t, env.Client, http.MethodGet, env.GW.URL+"/products/p3", nil, nil)
+		mustStatus(t, resp, raw, http.StatusOK)
+		var p catalog.Product
+		if err := json.Unmarshal(raw, &p); err != nil {
+			t.Fatalf("decode product: %v body=%s", err, string(raw))
+		}
+		return p
+	}
+
+	if p := getProduct(); p.Available != nil || !p.InStock {
+		t.Fatalf("untracked product=%+v", p)
+	}
+
+	// Only the order service's account may move stock for orders.
+	orderStock := map[string]any{"kind": "return", "reference": "o_forged", "lines": []map[string]any{{"product_id": "p3", "qty": 5}}}
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.Catalog.URL+"/internal/stock/orders", orderStock, nil)
+	mustStatus(t, resp, raw, http.StatusUnauthorized)
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.Catalog.URL+"/internal/stock/orders", orderStock, user)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	movements := env.GW.URL + "/products/p3/stock/movements"
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{"kind": "receipt", "delta": 3}, user)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{"kind": "receipt", "delta": -3}, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{"kind": "restock", "delta": 3}, admin)
+	mustStatus(t, resp, raw, http.StatusBadRequest)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{
+		"kind": "receipt", "delta": 3, "reference": "PO-1",
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	// The same delivery recorded twice only counts once.
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{
+		"kind": "receipt", "delta": 3, "reference": "PO-1",
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	if p := getProduct(); p.Available == nil || *p.Available != 3 || !p.InStock {
+		t.Fatalf("after receipt product=%+v", p)
+	}
+
+	first := createOrder(t, env, buyer, []map[string]any{{"product_id": "p3", "qty": 2}})
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders", map[string]any{
+		"items": []map[string]any{{"product_id": "p1", "qty": 1}, {"product_id": "p3", "qty": 2}},
+	}, map[string]string{"Authorization": "Bearer " + buyer})
+	mustStatus(t, resp, raw, http.StatusConflict)
+
+	var e struct {
+		Error   string `json:"error"`
+		Details struct {
+			ProductIDs []string `json:"product_ids"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(raw, &e); err != nil {
+		t.Fatalf("decode error: %v body=%s", err, string(raw))
+	}
+	if e.Error != "out of stock" || len(e.Details.ProductIDs) != 1 || e.Details.ProductIDs[0] != "p3" {
+		t.Fatalf("error=%+v", e)
+	}
+
+	if p := getProduct(); p.Available == nil || *p.Available != 1 {
+		t.Fatalf("after sale product=%+v", p)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{"kind": "adjustment", "delta": -2}, admin)
+	mustStatus(t, resp, raw, http.StatusConflict)
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, movements, map[string]any{
+		"kind": "adjustment", "delta": -1, "note": "damaged",
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	if p := getProduct(); p.Available == nil || *p.Available != 0 || p.InStock {
+		t.Fatalf("after adjustment product=%+v", p)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodPost, env.GW.URL+"/orders/"+first.ID+"/cancel", nil, map[string]string{
+		"Authorization": "Bearer " + buyer,
+	})
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	if p := getProduct(); p.Available == nil || *p.Available != 2 || !p.InStock {
+		t.Fatalf("after return product=%+v", p)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, movements+"?limit=3", nil, user)
+	mustStatus(t, resp, raw, http.StatusForbidden)
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, movements+"?limit=3", nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+
+	var page struct {
+		Items      []catalog.Movement `json:"items"`
+		NextCursor string             `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(raw, &page); err != nil {
+		t.Fatalf("decode movements: %v body=%s", err, string(raw))
+	}
+	var kinds []string
+	for _, m := range page.Items {
+		kinds = append(kinds, m.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "return,adjustment,sale" || page.NextCursor == "" {
+		t.Fatalf("kinds=%s next=%q", got, page.NextCursor)
+	}
+	if page.Items[0].Reference != first.ID || page.Items[0].StockAfter != 2 || page.Items[1].ActorID != "u_admin" {
+		t.Fatalf("movements=%+v", page.Items)
+	}
+
+	resp, raw = doJSON(t, env.Client, http.MethodGet, movements+"?cursor="+page.NextCursor, nil, admin)
+	mustStatus(t, resp, raw, http.StatusOK)
+	page.NextCursor = ""
+	if err := json.Unmarshal(raw, &page); err != nil {
+		t.Fatalf("decode movements: %v body=%s", err, string(raw))
+	}
+	if len(page.Items) != 1 || page.Items[0].Kind != catalog.MovementReceipt || page.NextCursor != "" {
+		t.Fatalf("second page=%+v", page)
+	}
+
+	// Untracked products keep selling.
+	createOrder(t, env, buyer, []map[string]any{{"product_id": "p1", "qty": 50}})
+}
+
+func TestGateway_PublicAPI_OrdersWithoutStockReservation(t *testing.T) {
+	t.Parallel()
+
+	catalogTS := newCatalogTS(t, jwtSecret)
+	t.Cleanup(catalogTS.Close)
+
+	orderTS := httptest.NewServer(order.NewHandler(&order.Server{
+		Store:   order.NewMemStore(),
+		Catalog: order.NewCatalogClient(catalogTS.URL, nil),
+		Log:     zap.NewNop(),
+	}, order.HTTPDeps{Log: zap.NewNop(), Service: "order", JWTSecret: jwtSecret}))
+	t.Cleanup(orderTS.Close)
+
+	client := &http.Client{}
+	admin := map[string]string{"Authorization": "Bearer " + issueToken(t, "u_admin", "admin")}
+
+	resp, raw := doJSON(t, client, http.MethodPost, catalogTS.URL+"/products/p1/stock/movements", map[string]any{
+		"kind": "receipt", "delta": 1,
+	}, admin)
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	// Without a service account the order service leaves catalog stock alone.
+	resp, raw = doJSON(t, client, http.MethodPost, orderTS.URL+"/orders", map[string]any{
+		"items": []map[string]any{{"product_id": "p1", "qty": 2}},
+	}, map[string]string{"Authorization": "Bearer " + issueToken(t, "u_buyer", "user")})
+	mustStatus(t, resp, raw, http.StatusCreated)
+
+	resp, raw = doJSON(t, client, http.MethodGet, catalogTS.URL+"/products/p1", nil, nil)
+	mustStatus(t, resp, raw, http.StatusOK)
+	var p catalog.Product
+	if err := json.Unmarshal(raw, &p); err != nil {
+		t.Fatalf("decode product: %v body=%s", err, string(raw))
+	}
+	if p.Available == nil || *p.Available != 1 {
+		t.Fatalf("product=%+v", p)
+	}
+}
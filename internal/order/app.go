@@ -20,20 +20,26 @@ type HTTPDeps struct {
 	Service  string
 	Registry *prometheus.Registry
 
-	JWTSecret string
+	AuthMode       string
+	JWTSecret      string
+	JWKSURL        string
+	IdentitySecret string
+
+	RequireVerifiedEmail bool
 
 	MetricsEnabled bool
 	MetricsToken   string
 }
 
-const readyTimeout = 1 * time.Second
+const (
+	readyTimeout = 1 * time.Second
 
-func NewHandler(s *Server, deps HTTPDeps) http.Handler {
-	if len(deps.JWTSecret) < 32 {
-		panic("JWTSecret is required and must be at least 32 chars")
-	}
+	AuthModeJWT     = "jwt"
+	AuthModeGateway = "gateway"
+)
 
-	jwt := auth.NewTokenMaker(deps.JWTSecret)
+func NewHandler(s *Server, deps HTTPDeps) http.Handler {
+	authMW := buildAuth(deps)
 
 	r := chi.NewRouter()
 	setupMiddleware(r, deps)
@@ -43,14 +49,39 @@ func NewHandler(s *Server, deps HTTPDeps) http.Handler {
 	r.Get("/readyz", readyz(s))
 
 	r.Group(func(pr chi.Router) {
-		pr.Use(AuthJWT(jwt))
-		pr.Post("/orders", s.CreateHandler())
+		pr.Use(authMW)
+		pr.Group(func(cr chi.Router) {
+			if deps.RequireVerifiedEmail {
+				cr.Use(RequireVerifiedEmail)
+			}
+			cr.With(s.Idempotent).Post("/orders", s.CreateHandler())
+		})
+		pr.Get("/orders", s.ListHandler())
 		pr.Get("/orders/{id}", s.GetHandler())
+		pr.Post("/orders/{id}/pay", s.PayHandler())
+		pr.Post("/orders/{id}/cancel", s.CancelHandler())
+
+		pr.Get("/internal/users/{id}/orders", s.ExportUserHandler())
+		pr.Post("/internal/users/{id}/erase", s.EraseUserHandler())
 	})
 
 	return r
 }
 
+func buildAuth(deps HTTPDeps) func(http.Handler) http.Handler {
+	if deps.AuthMode == AuthModeGateway {
+		if len(deps.IdentitySecret) < 32 {
+			panic("IdentitySecret is required and must be at least 32 chars")
+		}
+		return AuthGateway([]byte(deps.IdentitySecret))
+	}
+
+	if deps.JWKSURL == "" && len(deps.JWTSecret) < 32 {
+		panic("JWKSURL or JWTSecret (at least 32 chars) is required")
+	}
+	return AuthJWT(auth.NewTokenVerifier(deps.JWKSURL, deps.JWTSecret))
+}
+
 func setupMiddleware(r *chi.Mux, deps HTTPDeps) {
 	r.Use(chimw.RequestID)
 	r.Use(kit.Recoverer)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"MiniStore/internal/auth"
 	"MiniStore/pkg/kit"
@@ -17,8 +18,14 @@ const (
 )
 
 type User struct {
-	ID   string
-	Role string
+	ID            string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+func (u User) Principal() kit.Principal {
+	return kit.Principal{UserID: u.ID, Role: u.Role, Scopes: u.Scopes}
 }
 
 func UserFromContext(ctx context.Context) (User, bool) {
@@ -41,12 +48,53 @@ func AuthJWT(jwt *auth.TokenMaker) func(http.Handler) http.Handler {
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), userKey, User{ID: claims.UserID, Role: claims.Role})
+			ctx := context.WithValue(r.Context(), userKey, User{
+				ID:            claims.UserID,
+				Role:          claims.Role,
+				EmailVerified: claims.EmailVerified,
+				Scopes:        claims.Scopes(),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func AuthGateway(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := kit.VerifyIdentity(r, secret, time.Now())
+			if err != nil {
+				kit.WriteError(w, r, http.StatusUnauthorized, "invalid identity", nil)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey, User{
+				ID:            id.UserID,
+				Role:          id.Role,
+				EmailVerified: id.EmailVerified,
+				Scopes:        id.Scopes,
+			})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		if !ok {
+			kit.WriteError(w, r, http.StatusUnauthorized, "no user", nil)
+			return
+		}
+		// Service clients have no mailbox to verify.
+		if !u.EmailVerified && u.Role != kit.RoleService {
+			kit.WriteError(w, r, http.StatusForbidden, "email not verified", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func bearerToken(r *http.Request) (string, bool) {
 	authz := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authz, bearerPrefix) {
@@ -1,6 +1,7 @@
 package order
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"MiniStore/internal/auth"
 )
 
 type CatalogProduct struct {
@@ -29,48 +32,139 @@ const (
 	catalogTimeout = 3 * time.Second
 )
 
+// CatalogClient reads products anonymously; stock movements are sent with a
+// service token from Tokens. Without Tokens stock is not moved at all.
 type CatalogClient struct {
 	BaseURL string
 	Client  *http.Client
+	Tokens  auth.TokenSource
 }
 
-func NewCatalogClient(baseURL string) *CatalogClient {
+func NewCatalogClient(baseURL string, tokens auth.TokenSource) *CatalogClient {
 	baseURL = normalizeBaseURL(baseURL)
 
 	return &CatalogClient{
 		BaseURL: baseURL,
 		Client:  &http.Client{Timeout: catalogTimeout},
+		Tokens:  tokens,
 	}
 }
 
-func (c *CatalogClient) GetProduct(ctx context.Context, id string) (CatalogProduct, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/products/"+id, nil)
+type batchGetReq struct {
+	IDs []string `json:"ids"`
+}
+
+type batchGetResp struct {
+	Items   []CatalogProduct `json:"items"`
+	Missing []string         `json:"missing"`
+}
+
+func (c *CatalogClient) GetProducts(ctx context.Context, ids []string) ([]CatalogProduct, []string, error) {
+	body, err := json.Marshal(batchGetReq{IDs: ids})
 	if err != nil {
-		return CatalogProduct{}, err
+		return nil, nil, err
 	}
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/products:batchGet", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
 	resp, err := c.Client.Do(req)
 	if err != nil {
-		return CatalogProduct{}, mapCatalogError(err)
+		return nil, nil, mapCatalogError(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
+	if resp.StatusCode != http.StatusOK {
 		_, _ = io.Copy(io.Discard, resp.Body)
-		return CatalogProduct{}, ErrCatalogNotFound
+		return nil, nil, fmt.Errorf("%w: status=%d", ErrCatalogBadStatus, resp.StatusCode)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		_, _ = io.Copy(io.Discard, resp.Body)
-		return CatalogProduct{}, fmt.Errorf("%w: status=%d", ErrCatalogBadStatus, resp.StatusCode)
+	var out batchGetResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, nil, err
 	}
 
-	var p CatalogProduct
-	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
-		return CatalogProduct{}, err
+	return out.Items, out.Missing, nil
+}
+
+const (
+	stockSale   = "sale"
+	stockReturn = "return"
+)
+
+type StockLine struct {
+	ProductID string `json:"product_id"`
+	Qty       int    `json:"qty"`
+}
+
+type stockReq struct {
+	Kind      string      `json:"kind"`
+	Reference string      `json:"reference"`
+	Lines     []StockLine `json:"lines"`
+}
+
+// OutOfStockError lists the products the catalog could not sell.
+type OutOfStockError struct {
+	ProductIDs []string
+}
+
+func (e *OutOfStockError) Error() string {
+	return "out of stock: " + strings.Join(e.ProductIDs, ",")
+}
+
+// MoveStock records a sale or return of an order's items. The order ID is
+// the reference, which makes retries safe.
+func (c *CatalogClient) MoveStock(ctx context.Context, kind, orderID string, lines []StockLine) error {
+	if c.Tokens == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(stockReq{Kind: kind, Reference: orderID, Lines: lines})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/internal/stock/orders", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tok, err := c.Tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: service token: %w", ErrCatalogUnavailable, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return mapCatalogError(err)
 	}
+	defer resp.Body.Close()
 
-	return p, nil
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	case http.StatusConflict:
+		var out struct {
+			Details struct {
+				ProductIDs []string `json:"product_ids"`
+			} `json:"details"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return err
+		}
+		return &OutOfStockError{ProductIDs: out.Details.ProductIDs}
+	case http.StatusNotFound:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return ErrCatalogNotFound
+	default:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%w: status=%d", ErrCatalogBadStatus, resp.StatusCode)
+	}
 }
 
 func normalizeBaseURL(baseURL string) string {
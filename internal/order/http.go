@@ -21,18 +21,29 @@ type Server struct {
 	Store   Store
 	Catalog *CatalogClient
 	Log     *zap.Logger
+
+	IdempotencyTTL time.Duration
 }
 
 type createReq struct {
-	Items []Item `json:"items"`
+	Items []itemReq `json:"items"`
+}
+
+type itemReq struct {
+	ProductID string `json:"product_id"`
+	Qty       int    `json:"qty"`
 }
 
 const (
 	maxCreateBody = 1 << 20
+	maxOrderItems = 200
 )
 
 func (s *Server) CreateHandler() http.HandlerFunc { return s.create }
 func (s *Server) GetHandler() http.HandlerFunc    { return s.get }
+func (s *Server) ListHandler() http.HandlerFunc   { return s.list }
+func (s *Server) PayHandler() http.HandlerFunc    { return s.transition(StatusPaid) }
+func (s *Server) CancelHandler() http.HandlerFunc { return s.transition(StatusCancelled) }
 
 func (s *Server) create(w http.ResponseWriter, r *http.Request) {
 	u, ok := UserFromContext(r.Context())
@@ -51,22 +62,30 @@ func (s *Server) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	totalCents, err := s.calculateTotal(r.Context(), req.Items)
+	items, totalCents, err := s.priceItems(r.Context(), req.Items)
 	if err != nil {
 		s.writeCreateError(w, r, err)
 		return
 	}
 
+	now := time.Now().UTC()
 	o := Order{
 		ID:         "o_" + uuid.NewString(),
 		UserID:     u.ID,
-		Items:      req.Items,
+		Items:      items,
 		TotalCents: totalCents,
-		Status:     "NEW",
-		CreatedAt:  time.Now().UTC(),
+		Status:     StatusNew,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.reserveStock(r.Context(), o); err != nil {
+		s.writeCreateError(w, r, err)
+		return
 	}
 
 	if err := s.Store.Create(r.Context(), o); err != nil {
+		s.releaseStock(r.Context(), o)
 		if isTimeoutErr(err) {
 			kit.WriteError(w, r, http.StatusGatewayTimeout, "timeout", nil)
 			return
@@ -98,7 +117,7 @@ func (s *Server) get(w http.ResponseWriter, r *http.Request) {
 		kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": id})
 		return
 	}
-	if o.UserID != u.ID {
+	if !canAccess(u, o, kit.PermOrdersReadAny) {
 		kit.WriteError(w, r, http.StatusForbidden, "forbidden", nil)
 		return
 	}
@@ -106,6 +125,105 @@ func (s *Server) get(w http.ResponseWriter, r *http.Request) {
 	kit.WriteJSON(w, http.StatusOK, o)
 }
 
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := UserFromContext(r.Context())
+	if !ok {
+		kit.WriteError(w, r, http.StatusUnauthorized, "no user", nil)
+		return
+	}
+
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		kit.WriteError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	limit := q.Limit
+	q.Limit = limit + 1
+
+	orders, err := s.Store.ListByUser(r.Context(), u.ID, q)
+	if err != nil {
+		if isTimeoutErr(err) {
+			kit.WriteError(w, r, http.StatusGatewayTimeout, "timeout", nil)
+			return
+		}
+		if s.Log != nil {
+			s.Log.Error("store list orders failed", zap.Error(err), zap.String("user_id", u.ID))
+		}
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	resp := listResp{Items: orders}
+	if len(orders) > limit {
+		resp.Items = orders[:limit]
+		resp.NextCursor = cursorAfter(orders[limit-1]).Encode()
+	}
+
+	kit.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) transition(to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		if !ok {
+			kit.WriteError(w, r, http.StatusUnauthorized, "no user", nil)
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		o, found, err := s.Store.Get(r.Context(), id)
+		if err != nil {
+			if s.Log != nil {
+				s.Log.Error("store get order failed", zap.Error(err), zap.String("order_id", id))
+			}
+			kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+			return
+		}
+		if !found {
+			kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": id})
+			return
+		}
+		if !canAccess(u, o, kit.PermOrdersWriteAny) {
+			kit.WriteError(w, r, http.StatusForbidden, "forbidden", nil)
+			return
+		}
+
+		if !canTransition(o.Status, to) {
+			kit.WriteError(w, r, http.StatusConflict, ErrIllegalTransition.Error(), map[string]any{"from": o.Status, "to": to})
+			return
+		}
+
+		updated, err := s.Store.UpdateStatus(r.Context(), id, o.Status, to, time.Now().UTC())
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrOrderNotFound):
+				kit.WriteError(w, r, http.StatusNotFound, "not found", map[string]any{"id": id})
+			case errors.Is(err, ErrStatusConflict):
+				kit.WriteError(w, r, http.StatusConflict, "order status changed", nil)
+			case isTimeoutErr(err):
+				kit.WriteError(w, r, http.StatusGatewayTimeout, "timeout", nil)
+			default:
+				if s.Log != nil {
+					s.Log.Error("store update order status failed", zap.Error(err), zap.String("order_id", id))
+				}
+				kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+			}
+			return
+		}
+
+		if to == StatusCancelled {
+			s.releaseStock(r.Context(), updated)
+		}
+
+		kit.WriteJSON(w, http.StatusOK, updated)
+	}
+}
+
+func canAccess(u User, o Order, anyPerm string) bool {
+	return o.UserID == u.ID || u.Principal().Can(anyPerm)
+}
+
 func decodeCreateRequest(w http.ResponseWriter, r *http.Request) (createReq, error) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxCreateBody)
 	defer func() { _ = r.Body.Close() }()
@@ -127,59 +245,144 @@ func decodeCreateRequest(w http.ResponseWriter, r *http.Request) (createReq, err
 var (
 	errBadItem         = errors.New("bad item")
 	errDuplicateItem   = errors.New("duplicate product_id")
-	errInvalidProduct  = errors.New("invalid product_id")
+	errTooManyItems    = errors.New("too many items")
 	errCatalogDown     = errors.New("catalog unavailable")
 	errCatalogUpstream = errors.New("catalog error")
 	errTotalOverflow   = errors.New("total overflow")
 )
 
-func (s *Server) calculateTotal(ctx context.Context, items []Item) (int64, error) {
-	seen := make(map[string]struct{}, len(items))
-	var total int64
+type invalidProductsError struct {
+	ids []string
+}
+
+func (e *invalidProductsError) Error() string {
+	return "invalid product_id: " + strings.Join(e.ids, ",")
+}
+
+func (s *Server) priceItems(ctx context.Context, reqItems []itemReq) ([]Item, int64, error) {
+	if len(reqItems) > maxOrderItems {
+		return nil, 0, errTooManyItems
+	}
+
+	seen := make(map[string]struct{}, len(reqItems))
+	ids := make([]string, 0, len(reqItems))
 
-	for _, it := range items {
+	for _, it := range reqItems {
 		pid := strings.TrimSpace(it.ProductID)
 		if it.Qty <= 0 || pid == "" {
-			return 0, errBadItem
+			return nil, 0, errBadItem
 		}
 		if _, dup := seen[pid]; dup {
-			return 0, errDuplicateItem
+			return nil, 0, errDuplicateItem
 		}
 		seen[pid] = struct{}{}
+		ids = append(ids, pid)
+	}
 
-		p, err := s.Catalog.GetProduct(ctx, pid)
-		if err != nil {
-			switch err {
-			case ErrCatalogNotFound:
-				return 0, errInvalidProduct
-			case ErrCatalogUnavailable:
-				return 0, errCatalogDown
-			default:
-				if s.Log != nil {
-					s.Log.Warn("catalog error", zap.Error(err), zap.String("product_id", pid))
-				}
-				return 0, errCatalogUpstream
-			}
+	products, missing, err := s.Catalog.GetProducts(ctx, ids)
+	if err != nil {
+		if errors.Is(err, ErrCatalogUnavailable) {
+			return nil, 0, errCatalogDown
+		}
+		if s.Log != nil {
+			s.Log.Warn("catalog error", zap.Error(err), zap.Int("products", len(ids)))
+		}
+		return nil, 0, errCatalogUpstream
+	}
+	if len(missing) > 0 {
+		return nil, 0, &invalidProductsError{ids: missing}
+	}
+
+	byID := make(map[string]CatalogProduct, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	items := make([]Item, 0, len(reqItems))
+	var total int64
+	for i, it := range reqItems {
+		p, ok := byID[ids[i]]
+		if !ok {
+			return nil, 0, &invalidProductsError{ids: []string{ids[i]}}
 		}
 
 		line := p.PriceCents * int64(it.Qty)
-		if line < 0 || total > math.MaxInt64-line {
-			return 0, errTotalOverflow
+		if line < 0 || line/int64(it.Qty) != p.PriceCents || total > math.MaxInt64-line {
+			return nil, 0, errTotalOverflow
 		}
 		total += line
+
+		title, price := p.Title, p.PriceCents
+		items = append(items, Item{
+			ProductID:      p.ID,
+			Qty:            it.Qty,
+			Title:          &title,
+			UnitPriceCents: &price,
+			LineTotalCents: &line,
+		})
+	}
+
+	return items, total, nil
+}
+
+func stockLines(o Order) []StockLine {
+	lines := make([]StockLine, 0, len(o.Items))
+	for _, it := range o.Items {
+		lines = append(lines, StockLine{ProductID: it.ProductID, Qty: it.Qty})
+	}
+	return lines
+}
+
+// reserveStock records the order's sale in the catalog before the order is
+// stored, so two orders cannot both take the last unit.
+func (s *Server) reserveStock(ctx context.Context, o Order) error {
+	err := s.Catalog.MoveStock(ctx, stockSale, o.ID, stockLines(o))
+	if err == nil {
+		return nil
 	}
 
-	return total, nil
+	var oos *OutOfStockError
+	switch {
+	case errors.As(err, &oos):
+		return err
+	case errors.Is(err, ErrCatalogUnavailable):
+		return errCatalogDown
+	}
+	if s.Log != nil {
+		s.Log.Warn("catalog stock sale failed", zap.Error(err), zap.String("order_id", o.ID))
+	}
+	return errCatalogUpstream
+}
+
+// releaseStock puts a cancelled or unsaved order's items back. Failures are
+// only logged: the return is idempotent and can be replayed from the order.
+func (s *Server) releaseStock(ctx context.Context, o Order) {
+	err := s.Catalog.MoveStock(context.WithoutCancel(ctx), stockReturn, o.ID, stockLines(o))
+	if err != nil && s.Log != nil {
+		s.Log.Error("catalog stock return failed", zap.Error(err), zap.String("order_id", o.ID))
+	}
 }
 
 func (s *Server) writeCreateError(w http.ResponseWriter, r *http.Request, err error) {
+	var ipe *invalidProductsError
+	if errors.As(err, &ipe) {
+		kit.WriteError(w, r, http.StatusBadRequest, "invalid product_id", map[string]any{"product_ids": ipe.ids})
+		return
+	}
+
+	var oos *OutOfStockError
+	if errors.As(err, &oos) {
+		kit.WriteError(w, r, http.StatusConflict, "out of stock", map[string]any{"product_ids": oos.ProductIDs})
+		return
+	}
+
 	switch err {
 	case errBadItem:
 		kit.WriteError(w, r, http.StatusBadRequest, "bad item", nil)
 	case errDuplicateItem:
 		kit.WriteError(w, r, http.StatusBadRequest, "duplicate product_id", nil)
-	case errInvalidProduct:
-		kit.WriteError(w, r, http.StatusBadRequest, "invalid product_id", nil)
+	case errTooManyItems:
+		kit.WriteError(w, r, http.StatusBadRequest, "too many items", map[string]any{"max": maxOrderItems})
 	case errCatalogDown:
 		kit.WriteError(w, r, http.StatusServiceUnavailable, "catalog unavailable", nil)
 	case errCatalogUpstream:
@@ -0,0 +1,180 @@
+package order
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+const (
+	idempotencyHeader      = "Idempotency-Key"
+	idempotencyReplayedHdr = "Idempotent-Replayed"
+	maxIdempotencyKeyLen   = 255
+
+	DefaultIdempotencyTTL  = 24 * time.Hour
+	idempotencyLockTimeout = 1 * time.Minute
+)
+
+type IdempotencyRecord struct {
+	UserID      string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+type IdempotencyStore interface {
+	BeginIdempotent(ctx context.Context, rec IdempotencyRecord, expiredBefore time.Time) (IdempotencyRecord, bool, error)
+	CompleteIdempotent(ctx context.Context, userID, key string, status int, body []byte) error
+	ReleaseIdempotent(ctx context.Context, userID, key string) error
+	PurgeIdempotent(ctx context.Context, before time.Time) error
+}
+
+func idempotencyExpired(rec IdempotencyRecord, now, expiredBefore time.Time) bool {
+	if rec.CreatedAt.Before(expiredBefore) {
+		return true
+	}
+	return !rec.Completed() && rec.CreatedAt.Before(now.Add(-idempotencyLockTimeout))
+}
+
+func (s *Server) idempotencyTTL() time.Duration {
+	if s.IdempotencyTTL > 0 {
+		return s.IdempotencyTTL
+	}
+	return DefaultIdempotencyTTL
+}
+
+func (s *Server) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			kit.WriteError(w, r, http.StatusBadRequest, "bad idempotency key", map[string]any{"max_len": maxIdempotencyKeyLen})
+			return
+		}
+
+		u, ok := UserFromContext(r.Context())
+		if !ok {
+			kit.WriteError(w, r, http.StatusUnauthorized, "no user", nil)
+			return
+		}
+
+		raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCreateBody))
+		if err != nil {
+			kit.WriteError(w, r, http.StatusBadRequest, "bad json", nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+
+		sum := sha256.Sum256(raw)
+		now := time.Now().UTC()
+
+		rec, started, err := s.Store.BeginIdempotent(r.Context(), IdempotencyRecord{
+			UserID:      u.ID,
+			Key:         key,
+			RequestHash: hex.EncodeToString(sum[:]),
+			CreatedAt:   now,
+		}, now.Add(-s.idempotencyTTL()))
+		if err != nil {
+			s.logIdempotency("idempotency begin failed", err, u.ID, key)
+			kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+			return
+		}
+
+		if !started {
+			replayIdempotent(w, r, rec, hex.EncodeToString(sum[:]))
+			return
+		}
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+
+		ctx := context.WithoutCancel(r.Context())
+		if cw.status >= http.StatusInternalServerError {
+			if err := s.Store.ReleaseIdempotent(ctx, u.ID, key); err != nil {
+				s.logIdempotency("idempotency release failed", err, u.ID, key)
+			}
+			return
+		}
+		if err := s.Store.CompleteIdempotent(ctx, u.ID, key, cw.status, cw.buf.Bytes()); err != nil {
+			s.logIdempotency("idempotency complete failed", err, u.ID, key)
+		}
+	})
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, rec IdempotencyRecord, hash string) {
+	if rec.RequestHash != hash {
+		kit.WriteError(w, r, http.StatusUnprocessableEntity, "idempotency key reused with different request", nil)
+		return
+	}
+	if !rec.Completed() {
+		w.Header().Set("Retry-After", strconv.Itoa(1))
+		kit.WriteError(w, r, http.StatusConflict, "request with this idempotency key is in progress", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(idempotencyReplayedHdr, "true")
+	w.WriteHeader(rec.StatusCode)
+	_, _ = w.Write(rec.Body)
+}
+
+func (s *Server) RunIdempotencyJanitor(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Store.PurgeIdempotent(ctx, time.Now().UTC().Add(-s.idempotencyTTL())); err != nil && s.Log != nil {
+				s.Log.Warn("idempotency purge failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Server) logIdempotency(msg string, err error, userID, key string) {
+	if s.Log != nil {
+		s.Log.Error(msg, zap.Error(err), zap.String("user_id", userID), zap.String("idempotency_key", key))
+	}
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
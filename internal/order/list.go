@@ -0,0 +1,137 @@
+package order
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+var (
+	ErrBadCursor = errors.New("bad cursor")
+
+	errBadLimit  = errors.New("bad limit")
+	errBadStatus = errors.New("bad status")
+	errBadDate   = errors.New("bad date")
+	errBadRange  = errors.New("from > to")
+)
+
+type ListQuery struct {
+	Limit  int
+	After  *Cursor
+	Status string
+	From   *time.Time
+	To     *time.Time
+}
+
+type Cursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"id"`
+}
+
+type listResp struct {
+	Items      []Order `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return Cursor{}, ErrBadCursor
+	}
+	return c, nil
+}
+
+func cursorAfter(o Order) Cursor {
+	return Cursor{CreatedAt: o.CreatedAt, ID: o.ID}
+}
+
+func newerThan(a, b Cursor) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+func parseListQuery(v url.Values) (ListQuery, error) {
+	q := ListQuery{Limit: defaultListLimit}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return ListQuery{}, errBadLimit
+		}
+		q.Limit = n
+	}
+
+	if s := v.Get("status"); s != "" {
+		switch s {
+		case StatusNew, StatusPaid, StatusCancelled:
+			q.Status = s
+		default:
+			return ListQuery{}, errBadStatus
+		}
+	}
+
+	var err error
+	if q.From, err = parseDate(v.Get("from")); err != nil {
+		return ListQuery{}, err
+	}
+	if q.To, err = parseDate(v.Get("to")); err != nil {
+		return ListQuery{}, err
+	}
+	if q.From != nil && q.To != nil && q.From.After(*q.To) {
+		return ListQuery{}, errBadRange
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		c, err := DecodeCursor(s)
+		if err != nil {
+			return ListQuery{}, err
+		}
+		q.After = &c
+	}
+
+	return q, nil
+}
+
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errBadDate
+	}
+	return &t, nil
+}
+
+func matchesFilter(o Order, q ListQuery) bool {
+	if q.Status != "" && o.Status != q.Status {
+		return false
+	}
+	if q.From != nil && o.CreatedAt.Before(*q.From) {
+		return false
+	}
+	if q.To != nil && !o.CreatedAt.Before(*q.To) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package order
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"MiniStore/pkg/kit"
+)
+
+// pseudonymPrefix marks orders whose owner was erased; the rest of the ID is
+// random, so the orders of one erased user stay grouped without pointing
+// back at the account.
+const pseudonymPrefix = "erased_"
+
+type eraseResp struct {
+	UserID    string `json:"user_id"`
+	Pseudonym string `json:"pseudonym"`
+	Orders    int    `json:"orders"`
+}
+
+func (s *Server) ExportUserHandler() http.HandlerFunc { return s.exportUser }
+func (s *Server) EraseUserHandler() http.HandlerFunc  { return s.eraseUser }
+
+// exportUser lists every order of a user, for data subject access requests.
+func (s *Server) exportUser(w http.ResponseWriter, r *http.Request) {
+	if !requirePersonalData(w, r) {
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+	orders, err := s.Store.ListByUser(r.Context(), userID, ListQuery{})
+	if err != nil {
+		if isTimeoutErr(err) {
+			kit.WriteError(w, r, http.StatusGatewayTimeout, "timeout", nil)
+			return
+		}
+		if s.Log != nil {
+			s.Log.Error("store export orders failed", zap.Error(err), zap.String("user_id", userID))
+		}
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	kit.WriteJSON(w, http.StatusOK, listResp{Items: orders})
+}
+
+// eraseUser moves a user's orders to a fresh pseudonym. Totals, items and
+// statuses are kept for accounting.
+func (s *Server) eraseUser(w http.ResponseWriter, r *http.Request) {
+	if !requirePersonalData(w, r) {
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+	pseudonym := pseudonymPrefix + uuid.NewString()
+
+	n, err := s.Store.PseudonymizeUser(r.Context(), userID, pseudonym)
+	if err != nil {
+		if isTimeoutErr(err) {
+			kit.WriteError(w, r, http.StatusGatewayTimeout, "timeout", nil)
+			return
+		}
+		if s.Log != nil {
+			s.Log.Error("store pseudonymize orders failed", zap.Error(err), zap.String("user_id", userID))
+		}
+		kit.WriteError(w, r, http.StatusInternalServerError, "server error", nil)
+		return
+	}
+
+	if s.Log != nil {
+		u, _ := UserFromContext(r.Context())
+		s.Log.Info("orders pseudonymized", zap.String("actor_id", u.ID), zap.String("user_id", userID), zap.Int("orders", n))
+	}
+
+	kit.WriteJSON(w, http.StatusOK, eraseResp{UserID: userID, Pseudonym: pseudonym, Orders: n})
+}
+
+func requirePersonalData(w http.ResponseWriter, r *http.Request) bool {
+	u, ok := UserFromContext(r.Context())
+	if !ok {
+		kit.WriteError(w, r, http.StatusUnauthorized, "no user", nil)
+		return false
+	}
+	if !u.Principal().Can(kit.PermPersonalData) {
+		kit.WriteError(w, r, http.StatusForbidden, "forbidden", map[string]any{"permission": kit.PermPersonalData})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,24 @@
+package order
+
+import "errors"
+
+const (
+	StatusNew       = "NEW"
+	StatusPaid      = "PAID"
+	StatusCancelled = "CANCELLED"
+)
+
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+var transitions = map[string][]string{
+	StatusNew: {StatusPaid, StatusCancelled},
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,21 @@ package order
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var (
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrStatusConflict = errors.New("order status changed concurrently")
+)
+
 type Item struct {
-	ProductID string `json:"product_id"`
-	Qty       int    `json:"qty"`
+	ProductID      string  `json:"product_id"`
+	Qty            int     `json:"qty"`
+	Title          *string `json:"title"`
+	UnitPriceCents *int64  `json:"unit_price_cents"`
+	LineTotalCents *int64  `json:"line_total_cents"`
 }
 
 type Order struct {
@@ -17,10 +26,15 @@ type Order struct {
 	TotalCents int64     `json:"total_cents"`
 	Status     string    `json:"status"`
 	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 type Store interface {
 	Create(ctx context.Context, o Order) error
 	Get(ctx context.Context, id string) (Order, bool, error)
+	ListByUser(ctx context.Context, userID string, q ListQuery) ([]Order, error)
+	UpdateStatus(ctx context.Context, id, from, to string, at time.Time) (Order, error)
+	PseudonymizeUser(ctx context.Context, userID, pseudonym string) (int, error)
 	Ping(ctx context.Context) error
+	IdempotencyStore
 }
@@ -3,6 +3,8 @@ package order
 import (
 	"context"
 	"database/sql"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,6 +12,7 @@ const (
 	pingTimeout   = 1 * time.Second
 	createTimeout = 5 * time.Second
 	getTimeout    = 5 * time.Second
+	updateTimeout = 5 * time.Second
 )
 
 type PostgresStore struct {
@@ -62,10 +65,10 @@ func (s *PostgresStore) Get(ctx context.Context, id string) (Order, bool, error)
 
 	err := withTimeout(ctx, getTimeout, func(ctx context.Context) error {
 		if err := s.db.QueryRowContext(ctx, `
-			SELECT id, user_id, total_cents, status, created_at
+			SELECT id, user_id, total_cents, status, created_at, updated_at
 			FROM orders
 			WHERE id = $1
-		`, id).Scan(&o.ID, &o.UserID, &o.TotalCents, &o.Status, &o.CreatedAt); err != nil {
+		`, id).Scan(&o.ID, &o.UserID, &o.TotalCents, &o.Status, &o.CreatedAt, &o.UpdatedAt); err != nil {
 			return err
 		}
 
@@ -88,18 +91,269 @@ func (s *PostgresStore) Get(ctx context.Context, id string) (Order, bool, error)
 	return o, true, nil
 }
 
+func (s *PostgresStore) ListByUser(ctx context.Context, userID string, q ListQuery) ([]Order, error) {
+	query, args := buildListQuery(userID, q)
+
+	var out []Order
+	err := withTimeout(ctx, getTimeout, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		out = make([]Order, 0, q.Limit)
+		for rows.Next() {
+			var o Order
+			if err := rows.Scan(&o.ID, &o.UserID, &o.TotalCents, &o.Status, &o.CreatedAt, &o.UpdatedAt); err != nil {
+				return err
+			}
+			out = append(out, o)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return attachOrderItems(ctx, s.db, out)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func buildListQuery(userID string, q ListQuery) (string, []any) {
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"user_id = $1"}
+	if q.Status != "" {
+		where = append(where, "status = "+arg(q.Status))
+	}
+	if q.From != nil {
+		where = append(where, "created_at >= "+arg(*q.From))
+	}
+	if q.To != nil {
+		where = append(where, "created_at < "+arg(*q.To))
+	}
+	if q.After != nil {
+		where = append(where, "(created_at, id) < ("+arg(q.After.CreatedAt)+", "+arg(q.After.ID)+")")
+	}
+
+	query := `SELECT id, user_id, total_cents, status, created_at, updated_at FROM orders WHERE ` +
+		strings.Join(where, " AND ") +
+		` ORDER BY created_at DESC, id DESC`
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	return query, args
+}
+
+func (s *PostgresStore) UpdateStatus(ctx context.Context, id, from, to string, at time.Time) (Order, error) {
+	var o Order
+
+	err := withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		var cur string
+		err = tx.QueryRowContext(ctx, `
+			SELECT status
+			FROM orders
+			WHERE id = $1
+			FOR UPDATE
+		`, id).Scan(&cur)
+		if err == sql.ErrNoRows {
+			return ErrOrderNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if cur != from {
+			return ErrStatusConflict
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+			UPDATE orders
+			SET status = $2, updated_at = $3
+			WHERE id = $1
+			RETURNING id, user_id, total_cents, status, created_at, updated_at
+		`, id, to, at).Scan(&o.ID, &o.UserID, &o.TotalCents, &o.Status, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return err
+		}
+
+		items, err := loadOrderItems(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		o.Items = items
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		committed = true
+		return nil
+	})
+
+	if err != nil {
+		return Order{}, err
+	}
+	return o, nil
+}
+
+// PseudonymizeUser rewrites the owner of a user's orders and drops their
+// idempotency keys, whose stored responses still name the user.
+func (s *PostgresStore) PseudonymizeUser(ctx context.Context, userID, pseudonym string) (int, error) {
+	var n int64
+
+	err := withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+		if err != nil {
+			return err
+		}
+
+		committed := false
+		defer func() {
+			if !committed {
+				_ = tx.Rollback()
+			}
+		}()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE orders
+			SET user_id = $2
+			WHERE user_id = $1
+		`, userID, pseudonym)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE user_id = $1
+		`, userID); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		committed = true
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+func (s *PostgresStore) BeginIdempotent(ctx context.Context, rec IdempotencyRecord, expiredBefore time.Time) (IdempotencyRecord, bool, error) {
+	var (
+		out     IdempotencyRecord
+		started bool
+	)
+
+	err := withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		err := s.db.QueryRowContext(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash,
+			    status_code = NULL,
+			    response_body = NULL,
+			    created_at = EXCLUDED.created_at
+			WHERE idempotency_keys.created_at < $5
+			   OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $6)
+			RETURNING user_id
+		`, rec.UserID, rec.Key, rec.RequestHash, rec.CreatedAt, expiredBefore, rec.CreatedAt.Add(-idempotencyLockTimeout)).Scan(new(string))
+		if err == nil {
+			out, started = rec, true
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		var status sql.NullInt64
+		out = IdempotencyRecord{UserID: rec.UserID, Key: rec.Key}
+		err = s.db.QueryRowContext(ctx, `
+			SELECT request_hash, status_code, response_body, created_at
+			FROM idempotency_keys
+			WHERE user_id = $1 AND key = $2
+		`, rec.UserID, rec.Key).Scan(&out.RequestHash, &status, &out.Body, &out.CreatedAt)
+		out.StatusCode = int(status.Int64)
+		return err
+	})
+
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return out, started, nil
+}
+
+func (s *PostgresStore) CompleteIdempotent(ctx context.Context, userID, key string, status int, body []byte) error {
+	return withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE idempotency_keys
+			SET status_code = $3, response_body = $4
+			WHERE user_id = $1 AND key = $2
+		`, userID, key, status, body)
+		return err
+	})
+}
+
+func (s *PostgresStore) ReleaseIdempotent(ctx context.Context, userID, key string) error {
+	return withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE user_id = $1 AND key = $2 AND status_code IS NULL
+		`, userID, key)
+		return err
+	})
+}
+
+func (s *PostgresStore) PurgeIdempotent(ctx context.Context, before time.Time) error {
+	return withTimeout(ctx, updateTimeout, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE created_at < $1
+		`, before)
+		return err
+	})
+}
+
 func insertOrder(ctx context.Context, tx *sql.Tx, o Order) error {
 	_, err := tx.ExecContext(ctx, `
-		INSERT INTO orders (id, user_id, total_cents, status, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, o.ID, o.UserID, o.TotalCents, o.Status, o.CreatedAt)
+		INSERT INTO orders (id, user_id, total_cents, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, o.ID, o.UserID, o.TotalCents, o.Status, o.CreatedAt, o.UpdatedAt)
 	return err
 }
 
 func insertOrderItems(ctx context.Context, tx *sql.Tx, orderID string, items []Item) error {
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO order_items (order_id, product_id, qty)
-		VALUES ($1, $2, $3)
+		INSERT INTO order_items (order_id, product_id, qty, title, unit_price_cents, line_total_cents)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`)
 	if err != nil {
 		return err
@@ -107,7 +361,7 @@ func insertOrderItems(ctx context.Context, tx *sql.Tx, orderID string, items []I
 	defer stmt.Close()
 
 	for _, it := range items {
-		if _, err := stmt.ExecContext(ctx, orderID, it.ProductID, it.Qty); err != nil {
+		if _, err := stmt.ExecContext(ctx, orderID, it.ProductID, it.Qty, it.Title, it.UnitPriceCents, it.LineTotalCents); err != nil {
 			return err
 		}
 	}
@@ -117,7 +371,7 @@ func insertOrderItems(ctx context.Context, tx *sql.Tx, orderID string, items []I
 
 func loadOrderItems(ctx context.Context, q queryer, orderID string) ([]Item, error) {
 	rows, err := q.QueryContext(ctx, `
-		SELECT product_id, qty
+		SELECT product_id, qty, title, unit_price_cents, line_total_cents
 		FROM order_items
 		WHERE order_id = $1
 		ORDER BY product_id ASC
@@ -130,7 +384,7 @@ func loadOrderItems(ctx context.Context, q queryer, orderID string) ([]Item, err
 	out := make([]Item, 0, 8)
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.ProductID, &it.Qty); err != nil {
+		if err := rows.Scan(&it.ProductID, &it.Qty, &it.Title, &it.UnitPriceCents, &it.LineTotalCents); err != nil {
 			return nil, err
 		}
 		out = append(out, it)
@@ -143,6 +397,46 @@ func loadOrderItems(ctx context.Context, q queryer, orderID string) ([]Item, err
 	return out, nil
 }
 
+func attachOrderItems(ctx context.Context, q queryer, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(orders))
+	idx := make(map[string]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		idx[o.ID] = i
+		orders[i].Items = make([]Item, 0, 4)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT order_id, product_id, qty, title, unit_price_cents, line_total_cents
+		FROM order_items
+		WHERE order_id = ANY($1)
+		ORDER BY order_id, product_id ASC
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID string
+			it      Item
+		)
+		if err := rows.Scan(&orderID, &it.ProductID, &it.Qty, &it.Title, &it.UnitPriceCents, &it.LineTotalCents); err != nil {
+			return err
+		}
+		if i, ok := idx[orderID]; ok {
+			orders[i].Items = append(orders[i].Items, it)
+		}
+	}
+
+	return rows.Err()
+}
+
 type queryer interface {
 	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 }
@@ -2,17 +2,26 @@ package order
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
 type MemStore struct {
 	mu     sync.RWMutex
 	orders map[string]Order
+	idem   map[idemKey]IdempotencyRecord
+}
+
+type idemKey struct {
+	userID string
+	key    string
 }
 
 func NewMemStore() *MemStore {
 	return &MemStore{
 		orders: make(map[string]Order),
+		idem:   make(map[idemKey]IdempotencyRecord),
 	}
 }
 
@@ -38,3 +47,115 @@ func (s *MemStore) Get(ctx context.Context, id string) (Order, bool, error) {
 
 	return o, true, nil
 }
+
+func (s *MemStore) ListByUser(ctx context.Context, userID string, q ListQuery) ([]Order, error) {
+	s.mu.RLock()
+	out := make([]Order, 0, q.Limit)
+	for _, o := range s.orders {
+		if o.UserID != userID || !matchesFilter(o, q) {
+			continue
+		}
+		if q.After != nil && !newerThan(*q.After, cursorAfter(o)) {
+			continue
+		}
+		out = append(out, o)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return newerThan(cursorAfter(out[i]), cursorAfter(out[j])) })
+
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (s *MemStore) UpdateStatus(ctx context.Context, id, from, to string, at time.Time) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	if o.Status != from {
+		return Order{}, ErrStatusConflict
+	}
+
+	o.Status = to
+	o.UpdatedAt = at
+	s.orders[id] = o
+	return o, nil
+}
+
+func (s *MemStore) PseudonymizeUser(ctx context.Context, userID, pseudonym string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, o := range s.orders {
+		if o.UserID == userID {
+			o.UserID = pseudonym
+			s.orders[id] = o
+			n++
+		}
+	}
+	for k := range s.idem {
+		if k.userID == userID {
+			delete(s.idem, k)
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) BeginIdempotent(ctx context.Context, rec IdempotencyRecord, expiredBefore time.Time) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := idemKey{userID: rec.UserID, key: rec.Key}
+	if cur, ok := s.idem[k]; ok && !idempotencyExpired(cur, rec.CreatedAt, expiredBefore) {
+		return cur, false, nil
+	}
+
+	s.idem[k] = rec
+	return rec, true, nil
+}
+
+func (s *MemStore) CompleteIdempotent(ctx context.Context, userID, key string, status int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := idemKey{userID: userID, key: key}
+	rec, ok := s.idem[k]
+	if !ok {
+		return nil
+	}
+
+	rec.StatusCode = status
+	rec.Body = append([]byte(nil), body...)
+	s.idem[k] = rec
+	return nil
+}
+
+func (s *MemStore) ReleaseIdempotent(ctx context.Context, userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := idemKey{userID: userID, key: key}
+	if rec, ok := s.idem[k]; ok && !rec.Completed() {
+		delete(s.idem, k)
+	}
+	return nil
+}
+
+func (s *MemStore) PurgeIdempotent(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, rec := range s.idem {
+		if rec.CreatedAt.Before(before) {
+			delete(s.idem, k)
+		}
+	}
+	return nil
+}
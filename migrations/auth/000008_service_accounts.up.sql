@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+    );
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id                 TEXT PRIMARY KEY,
+    service_account_id TEXT NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    key_hash           TEXT NOT NULL,
+    scopes             TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMPTZ NOT NULL,
+    last_used_at       TIMESTAMPTZ,
+    revoked_at         TIMESTAMPTZ
+    );
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_service_account
+    ON api_keys(service_account_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS access_revocations_users;
+DROP TABLE IF EXISTS access_revocations_tokens;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS access_revocations_tokens (
+    jti        TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_access_revocations_tokens_expires
+    ON access_revocations_tokens(expires_at);
+
+CREATE TABLE IF NOT EXISTS access_revocations_users (
+    user_id       TEXT PRIMARY KEY,
+    issued_before TIMESTAMPTZ NOT NULL
+    );
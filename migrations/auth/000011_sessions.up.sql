@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ
+    );
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user
+    ON sessions(user_id, last_seen_at);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_revoked
+    ON sessions(revoked_at)
+    WHERE revoked_at IS NOT NULL;
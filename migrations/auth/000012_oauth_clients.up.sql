@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id            TEXT PRIMARY KEY,
+    name          TEXT NOT NULL,
+    secret_hash   TEXT NOT NULL DEFAULT '',
+    redirect_uris TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL
+    );
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    code_hash      TEXT PRIMARY KEY,
+    client_id      TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri   TEXT NOT NULL,
+    scope          TEXT NOT NULL DEFAULT '',
+    nonce          TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL,
+    user_agent     TEXT NOT NULL DEFAULT '',
+    ip             TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    used_at        TIMESTAMPTZ
+    );
+
+CREATE INDEX IF NOT EXISTS idx_oauth_codes_expires
+    ON oauth_codes(expires_at);
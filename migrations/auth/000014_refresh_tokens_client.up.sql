@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT '';
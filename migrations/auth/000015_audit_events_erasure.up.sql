@@ -0,0 +1,15 @@
+-- Erasure blanks the client IP and user agent of a user's own events; every
+-- other change is still refused.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip = '' AND NEW.user_agent = ''
+        AND (NEW.id, NEW.type, NEW.user_id, NEW.actor_id, NEW.request_id, NEW.details, NEW.created_at)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.type, OLD.user_id, OLD.actor_id, OLD.request_id, OLD.details, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
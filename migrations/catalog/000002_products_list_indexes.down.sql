@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_title_lower;
+DROP INDEX IF EXISTS idx_products_created_at_id;
+DROP INDEX IF EXISTS idx_products_price_id;
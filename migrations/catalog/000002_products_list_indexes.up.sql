@@ -0,0 +1,8 @@
+CREATE INDEX IF NOT EXISTS idx_products_price_id
+    ON products(price_cents, id);
+
+CREATE INDEX IF NOT EXISTS idx_products_created_at_id
+    ON products(created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_products_title_lower
+    ON products(lower(title) text_pattern_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_stock;
+DROP TABLE IF EXISTS stock_movements;
+ALTER TABLE products DROP COLUMN IF EXISTS stock;
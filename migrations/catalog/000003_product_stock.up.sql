@@ -0,0 +1,26 @@
+-- NULL stock means the product is not tracked and is always available.
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS stock INTEGER CHECK (stock >= 0);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id          BIGSERIAL PRIMARY KEY,
+    product_id  TEXT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    kind        TEXT NOT NULL CHECK (kind IN ('receipt', 'adjustment', 'sale', 'return')),
+    delta       INTEGER NOT NULL CHECK (delta <> 0),
+    stock_after INTEGER NOT NULL CHECK (stock_after >= 0),
+    reference   TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    actor_id    TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product_id
+    ON stock_movements(product_id, id DESC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_movements_reference
+    ON stock_movements(kind, reference, product_id)
+    WHERE reference <> '';
+
+CREATE INDEX IF NOT EXISTS idx_products_stock
+    ON products(stock)
+    WHERE stock IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE order_items
+    DROP COLUMN IF EXISTS line_total_cents,
+    DROP COLUMN IF EXISTS unit_price_cents,
+    DROP COLUMN IF EXISTS title;
@@ -0,0 +1,15 @@
+ALTER TABLE order_items
+    ADD COLUMN IF NOT EXISTS title            TEXT,
+    ADD COLUMN IF NOT EXISTS unit_price_cents BIGINT CHECK (unit_price_cents >= 0),
+    ADD COLUMN IF NOT EXISTS line_total_cents BIGINT CHECK (line_total_cents >= 0);
+
+-- Rows created before snapshots existed keep NULL prices, except single-item
+-- orders where the order total is the line total.
+UPDATE order_items oi
+SET line_total_cents = o.total_cents,
+    unit_price_cents = o.total_cents / oi.qty
+FROM orders o
+WHERE oi.order_id = o.id
+  AND oi.line_total_cents IS NULL
+  AND o.total_cents % oi.qty = 0
+  AND (SELECT count(*) FROM order_items x WHERE x.order_id = o.id) = 1;
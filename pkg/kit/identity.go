@@ -0,0 +1,108 @@
+package kit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderUserID            = "X-User-ID"
+	HeaderUserRole          = "X-User-Role"
+	HeaderEmailVerified     = "X-Email-Verified"
+	HeaderScopes            = "X-Scopes"
+	HeaderRequestID         = "X-Request-ID"
+	HeaderIdentityTimestamp = "X-Identity-Timestamp"
+	HeaderIdentitySignature = "X-Identity-Signature"
+
+	IdentityMaxSkew = 1 * time.Minute
+)
+
+var (
+	ErrIdentityMissing = errors.New("identity headers missing")
+	ErrIdentityInvalid = errors.New("identity signature invalid")
+	ErrIdentityExpired = errors.New("identity timestamp out of range")
+)
+
+var identityHeaders = []string{
+	HeaderUserID,
+	HeaderUserRole,
+	HeaderEmailVerified,
+	HeaderScopes,
+	HeaderIdentityTimestamp,
+	HeaderIdentitySignature,
+}
+
+type Identity struct {
+	UserID        string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+	RequestID     string
+}
+
+func StripIdentity(h http.Header) {
+	for _, k := range identityHeaders {
+		h.Del(k)
+	}
+}
+
+// SignIdentity sets the identity headers on r. The signature also covers the
+// method and request URI, so captured headers cannot be replayed against a
+// different endpoint.
+func SignIdentity(r *http.Request, id Identity, secret []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	h := r.Header
+
+	h.Set(HeaderUserID, id.UserID)
+	h.Set(HeaderUserRole, id.Role)
+	h.Set(HeaderEmailVerified, strconv.FormatBool(id.EmailVerified))
+	if len(id.Scopes) > 0 {
+		h.Set(HeaderScopes, strings.Join(id.Scopes, " "))
+	}
+	h.Set(HeaderRequestID, id.RequestID)
+	h.Set(HeaderIdentityTimestamp, ts)
+	h.Set(HeaderIdentitySignature, identityMAC(id, r.Method, r.URL.RequestURI(), ts, secret))
+}
+
+func VerifyIdentity(r *http.Request, secret []byte, now time.Time) (Identity, error) {
+	h := r.Header
+	id := Identity{
+		UserID:        h.Get(HeaderUserID),
+		Role:          h.Get(HeaderUserRole),
+		EmailVerified: h.Get(HeaderEmailVerified) == "true",
+		Scopes:        strings.Fields(h.Get(HeaderScopes)),
+		RequestID:     h.Get(HeaderRequestID),
+	}
+	ts := h.Get(HeaderIdentityTimestamp)
+	sig := h.Get(HeaderIdentitySignature)
+
+	if id.UserID == "" || ts == "" || sig == "" {
+		return Identity{}, ErrIdentityMissing
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(identityMAC(id, r.Method, r.URL.RequestURI(), ts, secret))) {
+		return Identity{}, ErrIdentityInvalid
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Identity{}, ErrIdentityInvalid
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > IdentityMaxSkew || d < -IdentityMaxSkew {
+		return Identity{}, ErrIdentityExpired
+	}
+
+	return id, nil
+}
+
+func identityMAC(id Identity, method, uri, ts string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{id.UserID, id.Role, strconv.FormatBool(id.EmailVerified), strings.Join(id.Scopes, " "), id.RequestID, method, uri, ts}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,99 @@
+package kit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyIdentity(t *testing.T) {
+	secret := []byte("identity-secret-32-chars-minimum....")
+	now := time.Unix(1_700_000_000, 0)
+	id := Identity{UserID: "u1", Role: RoleUser, EmailVerified: true, RequestID: "req-1"}
+
+	signed := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/orders/o1/pay?x=1", nil)
+		SignIdentity(r, id, secret, now)
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(r *http.Request) *http.Request
+		secret []byte
+		at     time.Time
+		want   error
+	}{
+		{name: "valid", want: nil},
+		{
+			name: "other path",
+			mutate: func(r *http.Request) *http.Request {
+				out := httptest.NewRequest(http.MethodPost, "/orders/o2/pay?x=1", nil)
+				out.Header = r.Header
+				return out
+			},
+			want: ErrIdentityInvalid,
+		},
+		{
+			name: "other method",
+			mutate: func(r *http.Request) *http.Request {
+				r.Method = http.MethodDelete
+				return r
+			},
+			want: ErrIdentityInvalid,
+		},
+		{
+			name: "other query",
+			mutate: func(r *http.Request) *http.Request {
+				r.URL.RawQuery = "x=2"
+				return r
+			},
+			want: ErrIdentityInvalid,
+		},
+		{
+			name: "tampered role",
+			mutate: func(r *http.Request) *http.Request {
+				r.Header.Set(HeaderUserRole, RoleAdmin)
+				return r
+			},
+			want: ErrIdentityInvalid,
+		},
+		{
+			name: "missing signature",
+			mutate: func(r *http.Request) *http.Request {
+				r.Header.Del(HeaderIdentitySignature)
+				return r
+			},
+			want: ErrIdentityMissing,
+		},
+		{name: "wrong secret", secret: []byte("another-secret-32-chars-minimum....."), want: ErrIdentityInvalid},
+		{name: "expired", at: now.Add(IdentityMaxSkew + time.Second), want: ErrIdentityExpired},
+		{name: "from the future", at: now.Add(-IdentityMaxSkew - time.Second), want: ErrIdentityExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := signed()
+			if tt.mutate != nil {
+				r = tt.mutate(r)
+			}
+			sec, at := secret, now
+			if tt.secret != nil {
+				sec = tt.secret
+			}
+			if !tt.at.IsZero() {
+				at = tt.at
+			}
+
+			got, err := VerifyIdentity(r, sec, at)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err=%v want=%v", err, tt.want)
+			}
+			if err == nil && (got.UserID != id.UserID || got.Role != id.Role || !got.EmailVerified || got.RequestID != id.RequestID) {
+				t.Fatalf("identity=%+v", got)
+			}
+		})
+	}
+}
@@ -25,7 +25,7 @@ func NewIPRateLimiter(limit int, windowSeconds int) *IPRateLimiter {
 
 func (l *IPRateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := clientIP(r)
+		ip := ClientIP(r)
 
 		now := time.Now()
 		cutoff := now.Add(-l.window)
@@ -67,7 +67,8 @@ func prune(ts []time.Time, cutoff time.Time) []time.Time {
 	return ts[:n]
 }
 
-func clientIP(r *http.Request) string {
+// ClientIP prefers the first X-Forwarded-For entry, as set by the gateway.
+func ClientIP(r *http.Request) string {
 	if ip := firstForwardedFor(r.Header.Get("X-Forwarded-For")); ip != "" {
 		return ip
 	}
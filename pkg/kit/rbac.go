@@ -0,0 +1,121 @@
+package kit
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+const (
+	RoleUser           = "user"
+	RoleSupport        = "support"
+	RoleCatalogManager = "catalog_manager"
+	RoleAdmin          = "admin"
+
+	// RoleService marks machine clients; it grants nothing by itself, their
+	// access comes from the scopes on the token. It cannot be granted to users.
+	RoleService = "service"
+)
+
+const (
+	PermProductsWrite    = "products:write"
+	PermOrdersReadAny    = "orders:read:any"
+	PermOrdersWriteAny   = "orders:write:any"
+	PermUsersRead        = "users:read"
+	PermUsersRolesWrite  = "users:roles:write"
+	PermUsersWrite       = "users:write"
+	PermServiceAccounts  = "service_accounts:write"
+	PermTokensIntrospect = "tokens:introspect"
+	PermAuditRead        = "audit:read"
+	PermOAuthClients     = "oauth_clients:write"
+	PermPersonalData     = "users:personal_data"
+	PermRevocationsRead  = "tokens:revocations:read"
+	PermStockOrders      = "stock:orders:write"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:           {},
+	RoleSupport:        {PermOrdersReadAny},
+	RoleCatalogManager: {PermProductsWrite},
+	RoleAdmin: {
+		PermProductsWrite,
+		PermOrdersReadAny,
+		PermOrdersWriteAny,
+		PermUsersRead,
+		PermUsersRolesWrite,
+		PermUsersWrite,
+		PermServiceAccounts,
+		PermTokensIntrospect,
+		PermAuditRead,
+		PermOAuthClients,
+		PermPersonalData,
+		PermRevocationsRead,
+		PermStockOrders,
+	},
+}
+
+type principalKey struct{}
+
+type Principal struct {
+	UserID string
+	Role   string
+	Scopes []string
+}
+
+// Can reports whether the principal holds perm through its role or, for
+// service clients, through a token scope.
+func (p Principal) Can(perm string) bool {
+	return HasPermission(p.Role, perm) || slices.Contains(p.Scopes, perm)
+}
+
+func (p Principal) CanAny(perms ...string) bool {
+	return slices.ContainsFunc(perms, p.Can)
+}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok && p.UserID != ""
+}
+
+func IsKnownRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, perm string) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+func IsKnownPermission(perm string) bool {
+	return HasPermission(RoleAdmin, perm)
+}
+
+func HasAnyPermission(role string, perms ...string) bool {
+	for _, p := range perms {
+		if HasPermission(role, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				WriteError(w, r, http.StatusUnauthorized, "missing token", nil)
+				return
+			}
+			if !p.Can(perm) {
+				WriteError(w, r, http.StatusForbidden, "forbidden", map[string]any{"permission": perm})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}